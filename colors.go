@@ -1,9 +1,16 @@
 package termcol
 
-type colorCode int
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Color is a foreground, background or underline color or a text attribute.
+type Color int
 
 const (
-	Reset colorCode = iota // Reset Formatting, §
+	Reset Color = iota // Reset Formatting, §
 
 	Black         // Color Black, &s
 	Red           // Color Red, &r
@@ -43,6 +50,29 @@ const (
 	BrightMagentaBg // Background Color Bright Magenta
 	BrightCyanBg    // Background Color Bright Cyan
 	BrightWhiteBg   // Background Color Bright White
+
+	Dim             // Text Dim, &D
+	Blink           // Text Blink, &K
+	Reverse         // Text Reverse Video, &V
+	Hidden          // Text Hidden, &X
+	Overline        // Text Overline, &O
+	DoubleUnderline // Text Double Underline, &E
+
+	CurlyUnderline  // Text Curly Underline, falls back to Underline
+	DottedUnderline // Text Dotted Underline, falls back to Underline
+	DashedUnderline // Text Dashed Underline, falls back to Underline
+
+	BoldOff               // Turns off Bold and Dim, &{/bold}
+	ItalicOff             // Turns off Italic, &{/italic}
+	UnderlineOff          // Turns off all Underline styles, &{/underline}
+	BlinkOff              // Turns off Blink, &{/blink}
+	ReverseOff            // Turns off Reverse, &{/reverse}
+	HiddenOff             // Turns off Hidden, &{/hidden}
+	StrikeThroughOff      // Turns off Strike Through, &{/strikethrough}
+	OverlineOff           // Turns off Overline, &{/overline}
+	DefaultFg             // Default Foreground Color, &{/fg}
+	DefaultBg             // Default Background Color, &{/bg}
+	DefaultUnderlineColor // Default Underline Color, &{/ul}
 )
 
 var colorValues = []string{
@@ -88,10 +118,33 @@ var colorValues = []string{
 	"\033[105m", // 34: BrightMagentaBg
 	"\033[106m", // 35: BrightCyanBg
 	"\033[107m", // 36: BrightWhiteBg
+
+	"\033[2m",  // 37: Dim
+	"\033[5m",  // 38: Blink
+	"\033[7m",  // 39: Reverse
+	"\033[8m",  // 40: Hidden
+	"\033[53m", // 41: Overline
+	"\033[21m", // 42: DoubleUnderline
+
+	"\033[4:3m", // 43: CurlyUnderline
+	"\033[4:4m", // 44: DottedUnderline
+	"\033[4:5m", // 45: DashedUnderline
+
+	"\033[22m", // 46: BoldOff
+	"\033[23m", // 47: ItalicOff
+	"\033[24m", // 48: UnderlineOff
+	"\033[25m", // 49: BlinkOff
+	"\033[27m", // 50: ReverseOff
+	"\033[28m", // 51: HiddenOff
+	"\033[29m", // 52: StrikeThroughOff
+	"\033[55m", // 53: OverlineOff
+	"\033[39m", // 54: DefaultFg
+	"\033[49m", // 55: DefaultBg
+	"\033[59m", // 56: DefaultUnderlineColor
 }
 
-// Mapping colorCode keys to colorCode values
-var colorKeys = map[rune]colorCode{
+// Mapping Color keys to Color values
+var colorKeys = map[rune]Color{
 	's': Black,         // &s
 	'r': Red,           // &r
 	'g': Green,         // &g
@@ -113,4 +166,241 @@ var colorKeys = map[rune]colorCode{
 	'I': Italic,        // &I
 	'U': Underline,     // &U
 	'S': StrikeThrough, // &S
+
+	'D': Dim,             // &D
+	'K': Blink,           // &K
+	'V': Reverse,         // &V
+	'X': Hidden,          // &X
+	'O': Overline,        // &O
+	'E': DoubleUnderline, // &E
+}
+
+// Mapping names of '{...}' color keys to Color values
+var colorNames = map[string]Color{
+	"reset":         Reset,
+	"black":         Black,
+	"red":           Red,
+	"green":         Green,
+	"yellow":        Yellow,
+	"blue":          Blue,
+	"magenta":       Magenta,
+	"cyan":          Cyan,
+	"white":         White,
+	"gray":          Gray,
+	"brightred":     BrightRed,
+	"brightgreen":   BrightGreen,
+	"brightyellow":  BrightYellow,
+	"brightblue":    BrightBlue,
+	"brightmagenta": BrightMagenta,
+	"brightcyan":    BrightCyan,
+	"brightwhite":   BrightWhite,
+
+	"bold":          Bold,
+	"italic":        Italic,
+	"underline":     Underline,
+	"strikethrough": StrikeThrough,
+
+	"dim":             Dim,
+	"blink":           Blink,
+	"reverse":         Reverse,
+	"hidden":          Hidden,
+	"overline":        Overline,
+	"doubleunderline": DoubleUnderline,
+	"curly":           CurlyUnderline,
+	"dotted":          DottedUnderline,
+	"dashed":          DashedUnderline,
+
+	"/bold":          BoldOff,
+	"/dim":           BoldOff,
+	"/italic":        ItalicOff,
+	"/underline":     UnderlineOff,
+	"/blink":         BlinkOff,
+	"/reverse":       ReverseOff,
+	"/hidden":        HiddenOff,
+	"/strikethrough": StrikeThroughOff,
+	"/overline":      OverlineOff,
+	"/fg":            DefaultFg,
+	"/bg":            DefaultBg,
+	"/ul":            DefaultUnderlineColor,
+}
+
+// Extended colors are encoded in the bits of a Color above the range of colorValues.
+const (
+	rgbColor     Color = 1 << 30 // 24-bit color, r<<16 | g<<8 | b in the lower bits
+	bgColor      Color = 1 << 29 // Applies an extended color to the background
+	paletteColor Color = 1 << 28 // 256-color palette index in the lower 8 bits
+	ulColor      Color = 1 << 27 // Applies an extended color to the underline
+)
+
+// Returned by the color constructors for malformed input, rejected as an invalid color code.
+const invalidColor Color = -1
+
+// RGB returns a 24-bit foreground color.
+func RGB(r, g, b uint8) Color {
+	return rgbColor | Color(r)<<16 | Color(g)<<8 | Color(b)
+}
+
+// RGBBg returns a 24-bit background color.
+func RGBBg(r, g, b uint8) Color {
+	return RGB(r, g, b) | bgColor
+}
+
+// Hex returns a 24-bit foreground color from a hex string like "#ff8800", "ff8800" or "#f80".
+// If the string is malformed, an invalid color code is returned.
+func Hex(s string) Color {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+	if len(s) != 6 {
+		return invalidColor
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return invalidColor
+	}
+	return rgbColor | Color(v)
+}
+
+// HexBg returns a 24-bit background color from a hex string (Further information in Hex)
+func HexBg(s string) Color {
+	c := Hex(s)
+	if c == invalidColor {
+		return c
+	}
+	return c | bgColor
+}
+
+// Color256 returns a foreground color from the xterm 256-color palette.
+func Color256(n uint8) Color {
+	return paletteColor | Color(n)
+}
+
+// Color256Bg returns a background color from the xterm 256-color palette.
+func Color256Bg(n uint8) Color {
+	return paletteColor | bgColor | Color(n)
+}
+
+// UnderlineRGB returns a 24-bit underline color.
+// Underline colors are only emitted with the Extended profile.
+func UnderlineRGB(r, g, b uint8) Color {
+	return RGB(r, g, b) | ulColor
+}
+
+// UnderlineHex returns a 24-bit underline color from a hex string (Further information in Hex)
+func UnderlineHex(s string) Color {
+	return underline(Hex(s))
+}
+
+// Underline256 returns an underline color from the xterm 256-color palette.
+func Underline256(n uint8) Color {
+	return Color256(n) | ulColor
+}
+
+// background returns the background variant of a foreground color, or an invalid color code if there is none.
+func background(c Color) Color {
+	switch {
+	case c >= Black && c <= BrightWhite:
+		return c - Black + BlackBg
+	case c >= BlackBg && c <= BrightWhiteBg:
+		return c
+	case c&(rgbColor|paletteColor) != 0:
+		return c | bgColor
+	default:
+		return invalidColor
+	}
+}
+
+// underline returns the underline color variant of a foreground color, or an invalid color code if there is none.
+func underline(c Color) Color {
+	switch {
+	case c >= Black && c <= BrightWhite:
+		return Underline256(uint8(c - Black))
+	case c&(rgbColor|paletteColor) != 0 && c&bgColor == 0:
+		return c | ulColor
+	default:
+		return invalidColor
+	}
+}
+
+// Sequence returns the ANSI escape code of the Color, or an empty string if it is invalid.
+func (c Color) Sequence() string {
+	if !isColorCode(c) {
+		return ""
+	}
+	return sequence(c)
+}
+
+// sequence returns the ANSI escape code for a valid Color.
+func sequence(c Color) string {
+	if c&rgbColor != 0 {
+		return fmt.Sprintf("\033[%d;2;%d;%d;%dm", layer(c), c>>16&0xff, c>>8&0xff, c&0xff)
+	}
+	if c&paletteColor != 0 {
+		return fmt.Sprintf("\033[%d;5;%dm", layer(c), c&0xff)
+	}
+	return colorValues[c]
+}
+
+// appendParams appends the SGR parameters of a valid Color to b, which are the escape code without "\033[" and "m".
+func appendParams(b []byte, c Color) []byte {
+	if c&(rgbColor|paletteColor) == 0 {
+		v := colorValues[c]
+		return append(b, v[2:len(v)-1]...)
+	}
+
+	b = strconv.AppendInt(b, int64(layer(c)), 10)
+	if c&rgbColor != 0 {
+		b = append(b, ";2;"...)
+		b = strconv.AppendInt(b, int64(c>>16&0xff), 10)
+		b = append(b, ';')
+		b = strconv.AppendInt(b, int64(c>>8&0xff), 10)
+		b = append(b, ';')
+	} else {
+		b = append(b, ";5;"...)
+	}
+	return strconv.AppendInt(b, int64(c&0xff), 10)
+}
+
+// layer returns the SGR parameter selecting the foreground, background or underline for an extended color.
+func layer(c Color) int {
+	switch {
+	case c&bgColor != 0:
+		return 48
+	case c&ulColor != 0:
+		return 58
+	default:
+		return 38
+	}
+}
+
+// parseColorValue parses the content of a '{...}' color key, e.g. "red", "#ff8800", "208", "bg:208" or "ul:red".
+func parseColorValue(spec string) Color {
+	bg, ul := false, false
+	if v, ok := strings.CutPrefix(spec, "bg:"); ok {
+		bg = true
+		spec = v
+	} else if v, ok := strings.CutPrefix(spec, "ul:"); ok {
+		ul = true
+		spec = v
+	}
+
+	color, ok := colorNames[strings.ToLower(spec)]
+	if !ok {
+		if strings.HasPrefix(spec, "#") {
+			color = Hex(spec)
+		} else if n, err := strconv.ParseUint(spec, 10, 8); err == nil {
+			color = Color256(uint8(n))
+		} else {
+			return invalidColor
+		}
+	}
+
+	if bg && color != invalidColor {
+		return background(color)
+	}
+	if ul && color != invalidColor {
+		return underline(color)
+	}
+	return color
 }
@@ -3,54 +3,132 @@ package termcol
 import (
 	"fmt"
 	"strings"
+	"unicode"
 )
 
+// ParseError describes an invalid format string or invalid colors passed to it.
+// Pos is the byte offset of the error in Text, or -1 if the error has no position.
+// Rune is the offset in runes, and Line and Column are the 1-based line and column (in runes) of the error.
+// Suggestion is a possible fix, like "did you mean &r?", which is only set by Validatef and Validatec.
 type ParseError struct {
-	Err  string
-	Text string
-	Pos  int
+	Err        string
+	Text       string
+	Pos        int
+	Rune       int
+	Line       int
+	Column     int
+	Suggestion string
+}
+
+// newParseError returns a ParseError at the byte offset pos of the text, or without position if pos is negative.
+func newParseError(msg, text string, pos int) ParseError {
+	if pos < 0 {
+		return ParseError{Err: msg, Text: text, Pos: -1, Rune: -1}
+	}
+
+	e := ParseError{Err: msg, Text: text, Pos: pos, Line: 1, Column: 1}
+	for _, r := range text[:pos] {
+		e.Rune++
+		e.Column++
+		if r == '\n' {
+			e.Line++
+			e.Column = 1
+		}
+	}
+	return e
 }
 
 func (e ParseError) Error() string {
+	hint := ""
+	if e.Suggestion != "" {
+		hint = " (" + e.Suggestion + ")"
+	}
+
 	if len(e.Text) == 0 {
-		return "termcol parse error: " + e.Err
+		return "termcol parse error: " + e.Err + hint
 	} else if e.Pos < 0 {
-		return fmt.Sprintf("termcol parse error: %s\n%s", e.Err, e.Text)
+		return fmt.Sprintf("termcol parse error: %s%s\n%s", e.Err, hint, e.Text)
 	} else if e.Pos >= len(e.Text) {
-		return "termcol parse error: Illegal character at end of text"
+		return "termcol parse error: Illegal character at end of text" + hint
 	}
 
-	start := e.Pos - 15
-	if start < 0 {
-		start = 0
-	}
-	end := e.Pos + 16
-	if end > len(e.Text) {
-		end = len(e.Text)
+	// Pos may be set to any byte offset, so the index of the rune containing it is used.
+	chars := []rune(e.Text)
+	pos := -1
+	for i := range e.Text {
+		if i > e.Pos {
+			break
+		}
+		pos++
 	}
 
-	text := e.Text[start:end]
-	caretPos := e.Pos - start
-	caretPos += strings.Count(text[:caretPos], "\n")
+	start := max(pos-15, 0)
+	end := min(pos+16, len(chars))
+
+	text := string(chars[start:end])
+	caretPos := displayWidth(chars[start:pos])
 	text = strings.ReplaceAll(text, "\n", `\n`)
 
 	if start > 0 {
 		text = "..." + text
 		caretPos += 3
 	}
-	if end < len(e.Text) {
+	if end < len(chars) {
 		text = text + "..."
 	}
 
 	if e.Err == "" {
 		return fmt.Sprintf(
-			"termcol parse error: Illegal character %q at index %d\n%s\n%s^ HERE",
-			e.Text[e.Pos], e.Pos, text, strings.Repeat(" ", caretPos),
+			"termcol parse error: Illegal character %q at index %d%s\n%s\n%s^ HERE",
+			chars[pos], pos, hint, text, strings.Repeat(" ", caretPos),
 		)
 	} else {
 		return fmt.Sprintf(
-			"termcol parse error: %s\n%s\n%s^ HERE",
-			e.Err, text, strings.Repeat(" ", caretPos),
+			"termcol parse error: %s%s\n%s\n%s^ HERE",
+			e.Err, hint, text, strings.Repeat(" ", caretPos),
 		)
 	}
 }
+
+// Ranges of runes displayed with double width in terminals
+var wideRanges = [][2]rune{
+	{0x1100, 0x115f}, {0x2e80, 0x303e}, {0x3041, 0x33ff}, {0x3400, 0x4dbf}, {0x4e00, 0x9fff},
+	{0xa000, 0xa4cf}, {0xac00, 0xd7a3}, {0xf900, 0xfaff}, {0xfe30, 0xfe4f}, {0xff00, 0xff60},
+	{0xffe0, 0xffe6}, {0x1f300, 0x1f64f}, {0x1f900, 0x1f9ff}, {0x20000, 0x2fffd}, {0x30000, 0x3fffd},
+}
+
+// displayWidth returns the number of terminal columns used by the runes, with newlines shown as `\n`.
+func displayWidth(chars []rune) int {
+	width := 0
+	for _, r := range chars {
+		switch {
+		case r == '\n':
+			width += 2
+		case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+		case isWide(r):
+			width += 2
+		default:
+			width++
+		}
+	}
+	return width
+}
+
+func isWide(r rune) bool {
+	for _, w := range wideRanges {
+		if r >= w[0] && r <= w[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrorMode decides how a Formatter handles invalid format strings and colors.
+type ErrorMode int
+
+const (
+	ErrorInline   ErrorMode = iota // Write an error message into the text
+	ErrorPlain                     // Return the text without colors
+	ErrorPanic                     // Panic with the ParseError
+	ErrorCallback                  // Return the text without colors and pass the ParseError to the callback
+)
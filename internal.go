@@ -2,131 +2,628 @@ package termcol
 
 import (
 	"fmt"
+	"slices"
 	"strings"
+	"sync"
+	"unicode/utf8"
 )
 
-func parse(f *Formatter, text string) []int {
-	var p []int
-	chars := []rune(text)
+// parse returns the byte offsets of the color keys in the text, skipping escaped keys.
+func parse(cfg *config, text string) []int {
+	var keys []int
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		i += size
+		if r != cfg.key {
+			continue
+		}
+		if next, nextSize := utf8.DecodeRuneInString(text[i:]); i < len(text) && next == cfg.key {
+			i += nextSize
+		} else {
+			keys = append(keys, i-size)
+		}
+	}
+	return keys
+}
+
+// Kinds of the last escape code written by replace, deciding whether a reset is needed at the end.
+const (
+	noEscape = iota
+	codeEscape
+	resetEscape
+)
+
+// Ways of reading color keys in replace
+const (
+	argKeys        = iota // One rune long, using the colors passed to Sprintc
+	valueKeys             // Followed by a color value, as in Sprintf
+	stripArgKeys          // Like argKeys, but removed without colors
+	stripValueKeys        // Like valueKeys, but removed without colors, keeping invalid keys as text
+)
+
+// Buffers for building strings, reused to avoid allocations
+var buffers = sync.Pool{New: func() any { return new([]byte) }}
+
+// replace returns the text with its color keys replaced by the escape codes of the profile p (see appendReplace).
+func replace(cfg *config, p Profile, text string, mode int, colors []Color) (string, error) {
+	buf := buffers.Get().(*[]byte)
+	b, err := appendReplace((*buf)[:0], cfg, p, text, mode, colors)
+	result := string(b)
+	if cap(b) <= 64<<10 {
+		*buf = b
+		buffers.Put(buf)
+	}
+	return result, err
+}
+
+/*
+appendReplace appends the text with its color keys replaced by the escape codes of the profile p to dst,
+in a single forward pass. Plain text is copied in runs between the runes with a special meaning.
+For invalid keys, the text with an error message is appended and returned together with the ParseError.
+*/
+func appendReplace(dst []byte, cfg *config, p Profile, text string, mode int, colors []Color) ([]byte, error) {
+	sprintf := mode == valueKeys || mode == stripValueKeys
+	strip := mode >= stripArgKeys
+
+	if mode == valueKeys {
+		if key, ok := danglingKey(cfg, text); ok {
+			return failKey(dst, text, key, len(text), key, "Color key without value at end of text")
+		}
+	}
+
+	// Index of the first invalid color passed to Sprintc
+	bad := -1
+	if mode == argKeys {
+		for i, c := range colors {
+			if !isColorCode(c) {
+				bad = i
+				break
+			}
+		}
+	}
+
+	w := getWriter(cfg, p, dst)
+	defer putWriter(w)
+	var stack []state
+	grouped := false
+	last := noEscape
+	k, badPos := 0, -1
+	start := 0 // Start of the plain text not written yet
+
+	for i := 0; i < len(text); {
+		r, size := rune(text[i]), 1
+		if r >= utf8.RuneSelf {
+			r, size = utf8.DecodeRuneInString(text[i:])
+		}
+		if r != cfg.key && r != cfg.resetKey && r != '\n' && r != ' ' {
+			i += size
+			continue
+		}
+		next, nextSize := utf8.RuneError, 0
+		if i+size < len(text) {
+			next, nextSize = utf8.DecodeRuneInString(text[i+size:])
+		}
+
+		newline := r == '\n' && p != NoColor && (cfg.restoreNewline && w.s != (state{}) || cfg.resetBeforeNewline)
+		// A space between two keys is stripped, so both are used for formatting.
+		space := r == ' ' && !sprintf && i > 0 && nextSize != 0 && next == cfg.key && prevRune(text, i) == cfg.key &&
+			!hasKey(cfg, text[i+size+nextSize:])
+		if r != cfg.key && r != cfg.resetKey && !newline && !space {
+			i += size
+			continue
+		}
+
+		if start < i {
+			w.raw(text[start:i])
+			grouped = false
+		}
+
+		switch {
+		case r == cfg.key && nextSize != 0 && next == cfg.key:
+			w.raw(text[i : i+size])
+			grouped = false
+			i += size + nextSize
+		case r == cfg.key:
+			color, end := invalidColor, i+size
+			if sprintf {
+				var pos int
+				var msg string
+				color, end, pos, msg = parseKey(cfg, text, i)
+				if color == invalidColor && !strip {
+					return failKey(dst, text, i, end, pos, msg)
+				}
+				if color == invalidColor {
+					// The invalid key is kept as plain text.
+					start = i
+					i += size
+					continue
+				}
+			} else if k < len(colors) && k != bad {
+				color = colors[k]
+			}
+			if k == bad {
+				badPos = i
+			}
+			k++
+			i = end
 
-	for i := 0; i < len(chars); i++ {
-		if chars[i] == f.key {
-			if i+1 < len(chars) && chars[i+1] == f.key {
-				i++
+			if strip || color == invalidColor {
+				break
+			}
+			if cfg.styleStack && !grouped {
+				stack = append(stack, w.s)
+			}
+			grouped = true
+			if color == Reset {
+				stack = stack[:0]
+				w.code(resetCodes(cfg, w.s)...)
+				last = resetEscape
 			} else {
-				p = append(p, i)
+				w.code(color)
+				if renders(p, color) {
+					last = codeEscape
+				}
 			}
+		case r == cfg.resetKey && nextSize != 0 && next == cfg.resetKey:
+			w.raw(text[i : i+size])
+			grouped = false
+			i += size + nextSize
+		case r == cfg.resetKey && len(stack) > 0:
+			prev := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			w.code(transition(cfg, w.s, prev)...)
+			grouped = false
+			last = resetEscape
+			if prev != (state{}) {
+				last = codeEscape
+			}
+			i += size
+		case r == cfg.resetKey:
+			w.code(resetCodes(cfg, w.s)...)
+			grouped = false
+			last = resetEscape
+			i += size
+		case newline && cfg.restoreNewline && w.s != (state{}):
+			s := w.s
+			w.code(resetCodes(cfg, s)...)
+			w.raw("\n")
+			w.code(transition(cfg, state{}, s)...)
+			grouped = false
+			i += size
+		case newline:
+			w.code(resetCodes(cfg, w.s)...)
+			w.flush()
+			w.raw("\n")
+			grouped = false
+			i += size
+		default:
+			// The stripped space
+			i += size
+		}
+		start = i
+	}
+	if start < len(text) {
+		w.raw(text[start:])
+	}
+
+	if mode == argKeys && (k != len(colors) || bad >= 0) {
+		var err ParseError
+		if k != len(colors) {
+			err = newParseError(
+				fmt.Sprintf("Number of colors (%d) does not match number of keys (%d)", len(colors), k), text, -1,
+			)
+		} else {
+			err = newParseError(fmt.Sprintf("Invalid color code %d as argument %d", colors[bad], bad+2), text, badPos)
+		}
+		return append(append(append(dst, "termcol: "...), err.Err+"\n"...), text...), err
+	}
+
+	if cfg.targetedResets {
+		if cfg.resetAtEnd {
+			w.code(resetCodes(cfg, w.s)...)
 		}
+	} else if cfg.resetAtEnd && last == codeEscape && w.s != (state{}) {
+		w.code(Reset)
 	}
+	w.flush()
 
-	return p
+	return w.b, nil
 }
 
-func replace(f *Formatter, text string, del int, keys []int, colors []colorCode) string {
-	chars := []rune(text)
+// prevRune returns the rune before the byte offset i of the text.
+func prevRune(text string, i int) rune {
+	r, _ := utf8.DecodeLastRuneInString(text[:i])
+	return r
+}
 
-	for i := len(keys) - 1; i >= 0; i-- {
-		pos := keys[i]
-		colorCode := []rune(colorValues[colors[i]])
-		chars = append(chars[:pos], append(colorCode, chars[pos+del:]...)...)
+// hasKey reports whether the text starts with the color key.
+func hasKey(cfg *config, text string) bool {
+	r, size := utf8.DecodeRuneInString(text)
+	return size != 0 && r == cfg.key
+}
 
-		if del == 1 && pos >= 2 && chars[pos-2] == '&' && chars[pos-1] == ' ' {
-			chars = append(chars[:pos-1], chars[pos:]...)
+// danglingKey returns the offset of a color key at the end of the text, which is not followed by a value.
+func danglingKey(cfg *config, text string) (int, bool) {
+	n, end := 0, len(text)
+	for end > 0 {
+		r, size := utf8.DecodeLastRuneInString(text[:end])
+		if r != cfg.key {
+			break
 		}
+		n++
+		end -= size
 	}
+	if n%2 == 0 {
+		return 0, false
+	}
+	_, size := utf8.DecodeLastRuneInString(text)
+	return len(text) - size, true
+}
 
-	for i := len(chars) - 1; i >= 0; i-- {
-		if chars[i] == f.resetKey {
-			if i > 0 && chars[i-1] == f.resetKey {
-				i--
-			} else {
-				resetRunes := []rune(colorValues[Reset])
-				chars = append(chars[:i], append(resetRunes, chars[i+1:]...)...)
+// failKey appends the text with the invalid key from start to end replaced by an error message to dst,
+// and returns it with the error at pos.
+func failKey(dst []byte, text string, start, end, pos int, msg string) ([]byte, error) {
+	err := newParseError(msg, text, pos)
+	if msg == "" {
+		r, _ := utf8.DecodeRuneInString(text[pos:])
+		msg = fmt.Sprintf("Invalid color key '%c'", r)
+	}
+	return append(append(dst, text[:start]...), "[termcol: "+msg+"]"+text[end:]...), err
+}
+
+// sequenceWriter appends text to b and collects the codes in between, to write them at once before the next text.
+type sequenceWriter struct {
+	cfg     *config
+	p       Profile
+	b       []byte
+	s       state  // Style after all codes
+	out     state  // Style after the codes written to b
+	known   bool   // The style before b is known, otherwise parts not written to b may be set
+	offs    uint64 // Off codes written to b, as bits 1<<c
+	pending []Color
+	codes   []Color // Buffer for the codes written by flush
+}
+
+// Writers reused by appendReplace, keeping the buffers for their codes
+var writers = sync.Pool{New: func() any { return new(sequenceWriter) }}
+
+// getWriter returns a reused writer appending to b.
+func getWriter(cfg *config, p Profile, b []byte) *sequenceWriter {
+	w := writers.Get().(*sequenceWriter)
+	*w = sequenceWriter{cfg: cfg, p: p, b: b, pending: w.pending[:0], codes: w.codes[:0]}
+	return w
+}
+
+// putWriter returns the writer for reuse, which must not be used afterwards.
+func putWriter(w *sequenceWriter) {
+	w.cfg, w.b = nil, nil
+	writers.Put(w)
+}
+
+// code applies codes to the style, which are written before the next text.
+func (w *sequenceWriter) code(codes ...Color) {
+	for _, c := range codes {
+		w.s.apply(c)
+	}
+	w.pending = append(w.pending, codes...)
+}
+
+// raw writes a string as it is, preceded by the pending codes.
+func (w *sequenceWriter) raw(s string) {
+	if len(w.pending) != 0 {
+		w.flush()
+	}
+	w.b = append(w.b, s...)
+}
+
+/*
+flush writes the pending codes.
+With combined sequences, only the codes changing the written style are written, merged into one sequence.
+As the text may follow styled output, resets and off codes are kept until a full reset is written.
+*/
+func (w *sequenceWriter) flush() {
+	if w.cfg.combineSequences {
+		w.codes = w.codes[:0]
+		if !w.known && slices.Contains(w.pending, Reset) {
+			w.codes = append(w.codes, Reset)
+			w.out = state{}
+		}
+		w.codes = appendTransition(w.codes, w.cfg, w.out, w.s)
+		if !w.known && !slices.Contains(w.codes, Reset) {
+			w.codes = appendOffCodes(w.codes, w.pending, w.s, w.offs)
+		}
+		for _, c := range w.codes {
+			w.known = w.known || c == Reset
+			if c < 64 {
+				w.offs |= 1 << c
 			}
 		}
+		w.b = appendSequences(w.b, w.cfg, w.p, w.codes)
+	} else {
+		w.b = appendSequences(w.b, w.cfg, w.p, w.pending)
+	}
+	w.pending = w.pending[:0]
+	w.out = w.s
+}
+
+// appendOffCodes appends the off codes of pending that are still in effect in the state t,
+// unless they are in codes or in the written bits.
+func appendOffCodes(codes, pending []Color, t state, written uint64) []Color {
+	for _, c := range pending {
+		var on state
+		on.apply(c)
+		s := t
+		s.apply(c)
+		if on == (state{}) && s == t && written&(1<<c) == 0 && !slices.Contains(codes, c) {
+			codes = append(codes, c)
+		}
 	}
+	return codes
+}
+
+// sequences returns the escape codes of the given codes, merged into one sequence with combined sequences.
+func sequences(cfg *config, p Profile, codes []Color) string {
+	return string(appendSequences(nil, cfg, p, codes))
+}
 
-	if f.resetAtEnd && len(colors) != 0 &&
-		colors[len(colors)-1] != Reset &&
-		strings.LastIndex(string(chars), colorValues[Reset]) != strings.LastIndex(string(chars), "\033[") {
-		chars = append(chars, []rune(colorValues[Reset])...)
+// appendSequences appends the escape codes of the given codes to b, merged into one sequence with combined sequences.
+func appendSequences(b []byte, cfg *config, p Profile, codes []Color) []byte {
+	written := false
+	for _, c := range codes {
+		if !renders(p, c) {
+			continue
+		}
+		if !cfg.combineSequences || !written {
+			b = append(b, "\033["...)
+		} else {
+			b = append(b, ';')
+		}
+		b = appendParams(b, renderColor(p, c))
+		if !cfg.combineSequences {
+			b = append(b, 'm')
+		}
+		written = true
+	}
+	if cfg.combineSequences && written {
+		b = append(b, 'm')
 	}
+	return b
+}
+
+// Codes of a full reset, which must not be modified
+var fullReset = []Color{Reset}
 
-	text = string(chars)
-	k := string(f.key)
-	resetK := string(f.resetKey)
-	text = strings.ReplaceAll(text, k+k, k)
-	text = strings.ReplaceAll(text, resetK+resetK, resetK)
-	if f.resetBeforeNewline {
-		text = strings.ReplaceAll(text, "\n", colorValues[Reset]+"\n")
+// resetCodes returns the codes resetting the state s,
+// which is a full reset unless targeted resets are enabled.
+func resetCodes(cfg *config, s state) []Color {
+	if !cfg.targetedResets {
+		return fullReset
 	}
+	return s.off()
+}
 
-	return text
+// transition returns the shortest codes changing the style from the state s to t.
+// Unless targeted resets are enabled, a full reset followed by the codes of t is used if it is shorter.
+func transition(cfg *config, s, t state) []Color {
+	return appendTransition(nil, cfg, s, t)
 }
 
-func colorize(f *Formatter, text string, colors []colorCode) string {
-	keys := parse(f, text)
-	if len(keys) != len(colors) {
-		b := strings.Builder{}
-		b.WriteString("termcol: Number of colors (")
-		b.WriteString(fmt.Sprint(len(colors)))
-		b.WriteString(") does not match number of keys (")
-		b.WriteString(fmt.Sprint(len(keys)))
-		b.WriteRune(')')
-		b.WriteRune('\n')
-		b.WriteString(text)
-		return b.String()
+// appendTransition appends the codes of transition(cfg, s, t) to codes.
+func appendTransition(codes []Color, cfg *config, s, t state) []Color {
+	start := len(codes)
+	codes = s.appendDiff(codes, t)
+	if cfg.targetedResets || s == (state{}) {
+		return codes
 	}
 
-	for i := 0; i < len(colors); i++ {
-		if colors[i] < 0 || int(colors[i]) >= len(colorValues) {
-			b := strings.Builder{}
-			b.WriteString("termcol: Invalid color code ")
-			b.WriteString(fmt.Sprint(colors[i]))
-			b.WriteString(" as argument ")
-			b.WriteString(fmt.Sprint(i + 2))
-			b.WriteRune('\n')
-			b.WriteString(text)
-			return b.String()
-		}
+	// The full reset and the codes of t are appended after the diff, to move them in its place if they are shorter.
+	n := len(codes) - start
+	codes = (state{}).appendDiff(append(codes, Reset), t)
+	if set := len(codes) - start - n - 1; set+1 < n || t == (state{}) {
+		return append(codes[:start], codes[start+n:]...)
+	}
+	return codes[:start+n]
+}
+
+// statusReset returns the codes resetting the color of a status message.
+func statusReset(cfg *config, p Profile, color Color) string {
+	var s state
+	s.apply(color)
+	return sequences(cfg, p, resetCodes(cfg, s))
+}
+
+// status formats a status message of the level and writes it ending with a newline, unless the level is below the minimum.
+func status(cfg *config, l level, text string, a []any) (int, error) {
+	if l.level < cfg.minLevel {
+		return 0, nil
+	}
+	return writeStatus(cfg, l, text, a)
+}
+
+// writeStatus formats a status message of the level and writes it ending with a newline, regardless of the minimum level.
+func writeStatus(cfg *config, l level, text string, a []any) (int, error) {
+	w := cfg.output
+	if l.level >= LevelWarning {
+		w = cfg.diagnostic
 	}
 
-	text = replace(f, text, 1, keys, colors)
-	return text
+	p := cfg.profileFor(w)
+	text = sprintf(cfg, p, text, a)
+	if cfg.resetAtEnd && !strings.Contains(text, "\033[") {
+		text = text + statusReset(cfg, p, l.color)
+	}
+	return fmt.Fprintln(w, render(p, l.color)+l.text+text)
+}
+
+func colorize(cfg *config, p Profile, text string, colors []Color) (string, error) {
+	return replace(cfg, p, text, argKeys, colors)
 }
 
-func format(f *Formatter, text string) string {
+func format(cfg *config, p Profile, text string) (string, error) {
 	if len(text) == 0 {
-		return text
+		return text, nil
+	}
+	return replace(cfg, p, text, valueKeys, nil)
+}
+
+/*
+parseKey parses the color key at the offset key and returns its color and the offset after it.
+For an invalid key, invalidColor is returned with the offset after the invalid part,
+the offset of the error and its message, which is empty for an invalid key character.
+*/
+func parseKey(cfg *config, text string, key int) (color Color, end int, pos int, msg string) {
+	_, size := utf8.DecodeRuneInString(text[key:])
+	i := key + size
+	if i >= len(text) {
+		return invalidColor, len(text), key, "Color key without value at end of text"
+	}
+
+	switch text[i] {
+	case '{':
+		end := strings.IndexByte(text[i+1:], '}')
+		if end < 0 {
+			return invalidColor, len(text), i, "Missing '}' in color key"
+		}
+		end += i + 1
+
+		spec := text[i+1 : end]
+		color := parseColorValue(spec)
+		if color == invalidColor {
+			return invalidColor, end + 1, i + 1, "Invalid color value '" + spec + "'"
+		}
+		return color, end + 1, 0, ""
+	case '!':
+		if i+1 >= len(text) {
+			return invalidColor, len(text), key, "Color key without value at end of text"
+		}
+		r, size := utf8.DecodeRuneInString(text[i+1:])
+		if c, ok := colorKeys[r]; ok && background(c) != invalidColor {
+			return background(c), i + 1 + size, 0, ""
+		}
+		return invalidColor, i + 1 + size, i + 1, fmt.Sprintf("Invalid background key '%c'", r)
+	}
+
+	r, size := utf8.DecodeRuneInString(text[i:])
+	color, ok := colorKeys[r]
+	if !ok {
+		return invalidColor, i + size, i, ""
+	}
+	return color, i + size, 0, ""
+}
+
+// sprintc colorizes the text, handling errors according to the error mode of the Formatter.
+func sprintc(cfg *config, p Profile, text string, colors []Color) string {
+	result, err := colorize(cfg, p, text, colors)
+	if err != nil {
+		return handleError(cfg, err, result, func() string {
+			return plain(cfg, text, false)
+		})
+	}
+	return result
+}
+
+// sprintf formats the text, handling errors according to the error mode of the Formatter.
+func sprintf(cfg *config, p Profile, text string, a []any) string {
+	result, err := format(cfg, p, text)
+	if err != nil {
+		result = handleError(cfg, err, result, func() string {
+			return plain(cfg, text, true)
+		})
+	}
+	if len(a) == 0 {
+		return result
+	}
+	return fmt.Sprintf(result, a...)
+}
+
+// appendSprintc appends the colorized text to dst, handling errors according to the error mode of the Formatter.
+func appendSprintc(dst []byte, cfg *config, p Profile, text string, colors []Color) []byte {
+	n := len(dst)
+	dst, err := appendReplace(dst, cfg, p, text, argKeys, colors)
+	if err != nil {
+		return append(dst[:n], handleError(cfg, err, string(dst[n:]), func() string {
+			return plain(cfg, text, false)
+		})...)
 	}
+	return dst
+}
 
-	keys := parse(f, text)
+// appendSprintf appends the formatted text to dst, handling errors according to the error mode of the Formatter.
+// Without arguments, the text is written directly to dst.
+func appendSprintf(dst []byte, cfg *config, p Profile, text string, a []any) []byte {
+	if len(a) != 0 {
+		return fmt.Appendf(dst, sprintf(cfg, p, text, nil), a...)
+	}
 
-	if len(keys) != 0 && keys[len(keys)-1]+1 >= len(text) {
-		return text[:len(text)-2] + "[termcol: Color key without value at end of text]"
+	n := len(dst)
+	dst, err := appendReplace(dst, cfg, p, text, valueKeys, nil)
+	if err != nil {
+		return append(dst[:n], handleError(cfg, err, string(dst[n:]), func() string {
+			return plain(cfg, text, true)
+		})...)
 	}
+	return dst
+}
 
-	var colors []colorCode
-	chars := []rune(text)
-	for _, key := range keys {
-		color, ok := colorKeys[chars[key+1]]
-		if !ok {
-			b := strings.Builder{}
-			b.WriteString(text[:key])
-			b.WriteString("[termcol: Invalid color key '")
-			b.WriteRune(chars[key+1])
-			b.WriteString("']")
-			b.WriteString(text[key+2:])
-			return b.String()
+// handleError returns the text to use for a failed format, which is the text with an error message for ErrorInline.
+func handleError(cfg *config, err error, inline string, plain func() string) string {
+	switch cfg.errorMode {
+	case ErrorPlain:
+		return plain()
+	case ErrorPanic:
+		panic(err)
+	case ErrorCallback:
+		if cfg.errorCallback != nil {
+			cfg.errorCallback(err.(ParseError))
 		}
-		colors = append(colors, color)
+		return plain()
+	default:
+		return inline
+	}
+}
+
+// plain returns the text without valid keys, escaped keys and colors, keeping invalid keys as they are written.
+// Keys are one rune long for Sprintc and include the color value for Sprintf.
+func plain(cfg *config, text string, sprintf bool) string {
+	mode := stripArgKeys
+	if sprintf {
+		mode = stripValueKeys
+	}
+	result, _ := replace(cfg, NoColor, text, mode, nil)
+	return result
+}
+
+// render returns the escape code for c as supported by the profile p, downsampling extended colors if needed.
+// Without the Extended profile, styled underlines fall back to Underline and underline colors are dropped.
+func render(p Profile, c Color) string {
+	if !renders(p, c) {
+		return ""
 	}
+	return sequence(renderColor(p, c))
+}
 
-	text = replace(f, text, 2, keys, colors)
+// renderColor returns the color written for c with the profile p, which must render it.
+func renderColor(p Profile, c Color) Color {
+	if p < Extended && c >= CurlyUnderline && c <= DashedUnderline {
+		return Underline
+	}
+	return downsample(p, c)
+}
 
-	return text
+// renders reports whether the profile p has an escape code for c.
+func renders(p Profile, c Color) bool {
+	return p != NoColor && (p == Extended || c&ulColor == 0 && c != DefaultUnderlineColor)
 }
 
-func isColorCode(c colorCode) bool {
-	return c >= 0 && int(c) < len(colorValues)
+func isColorCode(c Color) bool {
+	if c >= 0 && int(c) < len(colorValues) {
+		return true
+	}
+	if c&bgColor != 0 && c&ulColor != 0 {
+		return false
+	}
+	if c&rgbColor != 0 {
+		return c&^(rgbColor|bgColor|ulColor|0xffffff) == 0
+	}
+	return c&paletteColor != 0 && c&^(paletteColor|bgColor|ulColor|0xff) == 0
 }
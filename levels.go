@@ -0,0 +1,142 @@
+package termcol
+
+import (
+	"fmt"
+	"maps"
+	"os"
+)
+
+/*
+Level is the severity of a status message. Messages below the minimum level of the Formatter are discarded,
+and messages of LevelWarning and above are written to the diagnostic output.
+The levels are spaced apart, so custom levels can be placed between them, e.g. LevelInfo+1.
+*/
+type Level int
+
+const (
+	LevelDebug   Level = -4 // Details for debugging, hidden by default
+	LevelInfo    Level = 0  // Informational messages
+	LevelNotice  Level = 2  // Normal but significant messages
+	LevelSuccess Level = 3  // Successfully finished operations
+	LevelWarning Level = 4  // Possible problems
+	LevelError   Level = 8  // Failed operations
+	LevelFatal   Level = 12 // Errors after which the program exits
+)
+
+// level is a named level of status messages with the color and text of its prefix.
+type level struct {
+	level Level
+	color Color
+	text  string
+}
+
+// defaultLevels are the predefined levels, which are shared by all settings and must not be modified.
+var defaultLevels = map[string]level{
+	"debug":   {LevelDebug, Gray, "Debug: "},
+	"info":    {LevelInfo, Blue, "Info: "},
+	"notice":  {LevelNotice, Cyan, "Notice: "},
+	"success": {LevelSuccess, Green, "Success: "},
+	"warning": {LevelWarning, Yellow, "Warning: "},
+	"error":   {LevelError, Red, "Error: "},
+	"fatal":   {LevelFatal, BrightRed, "Fatal: "},
+}
+
+// exit terminates the program after a fatal message.
+var exit = os.Exit
+
+// setLevel stores the level under the name in a copy of the levels, as they may be shared with other settings.
+func (cfg *config) setLevel(name string, l level) {
+	levels := maps.Clone(cfg.levels)
+	if levels == nil {
+		levels = map[string]level{}
+	}
+	levels[name] = l
+	cfg.levels = levels
+}
+
+// setStyle changes the color and text of the named level, keeping its severity.
+func (cfg *config) setStyle(name string, color Color, text string) {
+	l := cfg.levels[name]
+	l.color, l.text = color, text
+	cfg.setLevel(name, l)
+}
+
+/*
+SetLevel registers a named level of status messages for Logf with its severity and prefix, or changes an existing one.
+The predefined levels are "debug", "info", "notice", "success", "warning", "error" and "fatal".
+*/
+func (f *Formatter) SetLevel(name string, lvl Level, color Color, text string) {
+	if name == "" || !isColorCode(color) {
+		return
+	}
+	f.update(func(cfg *config) { cfg.setLevel(name, level{lvl, color, text}) })
+}
+
+// SetMinLevel sets the level below which status messages are discarded, e.g. LevelDebug for verbose output. (Default: LevelInfo)
+func (f *Formatter) SetMinLevel(l Level) {
+	f.update(func(cfg *config) { cfg.minLevel = l })
+}
+
+/*
+Logf prints the text as a status message of the named level ending with a newline, prefixed with the text of the level in its color.
+Messages of LevelWarning and above are written to the diagnostic output, and messages below the minimum level are discarded.
+An error is returned if no level with the name is registered.
+*/
+func (f *Formatter) Logf(name, text string, a ...any) (int, error) {
+	cfg := f.load()
+	l, ok := cfg.levels[name]
+	if !ok {
+		return 0, fmt.Errorf("termcol: unknown level %q", name)
+	}
+	return status(cfg, l, text, a)
+}
+
+// Debugf prints the text as a debug message in gray, if the minimum level is lowered to LevelDebug.
+func (f *Formatter) Debugf(text string, a ...any) (int, error) {
+	return f.Logf("debug", text, a...)
+}
+
+// Infof prints the text as an informational message in blue.
+func (f *Formatter) Infof(text string, a ...any) (int, error) {
+	return f.Logf("info", text, a...)
+}
+
+// Noticef prints the text as a notice in cyan.
+func (f *Formatter) Noticef(text string, a ...any) (int, error) {
+	return f.Logf("notice", text, a...)
+}
+
+/*
+Fatalf prints the text as a fatal error in bright red to the diagnostic output and exits the program with status 1.
+The message is written even if the minimum level is above LevelFatal, and write errors are ignored, as the program exits anyway.
+*/
+func (f *Formatter) Fatalf(text string, a ...any) {
+	cfg := f.load()
+	_, _ = writeStatus(cfg, cfg.levels["fatal"], text, a)
+	exit(1)
+}
+
+// Logf is a Wrapper for defaultFormatter.Logf (Further information in Formatter.Logf)
+func Logf(name, text string, a ...any) (int, error) {
+	return df.Logf(name, text, a...)
+}
+
+// Debugf is a Wrapper for defaultFormatter.Debugf (Further information in Formatter.Debugf)
+func Debugf(text string, a ...any) (int, error) {
+	return df.Debugf(text, a...)
+}
+
+// Infof is a Wrapper for defaultFormatter.Infof (Further information in Formatter.Infof)
+func Infof(text string, a ...any) (int, error) {
+	return df.Infof(text, a...)
+}
+
+// Noticef is a Wrapper for defaultFormatter.Noticef (Further information in Formatter.Noticef)
+func Noticef(text string, a ...any) (int, error) {
+	return df.Noticef(text, a...)
+}
+
+// Fatalf is a Wrapper for defaultFormatter.Fatalf (Further information in Formatter.Fatalf)
+func Fatalf(text string, a ...any) {
+	df.Fatalf(text, a...)
+}
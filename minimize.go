@@ -0,0 +1,170 @@
+package termcol
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Formatter settings used by Minimize
+var minimizer = &config{combineSequences: true}
+
+// Mapping SGR parameters to Color values
+var sgrCodes = map[string]Color{
+	"4:0": UnderlineOff,
+	"4:1": Underline,
+	"4:2": DoubleUnderline,
+}
+
+func init() {
+	for i, v := range colorValues {
+		sgrCodes[v[2:len(v)-1]] = Color(i)
+	}
+}
+
+/*
+Minimize returns the text with the shortest equivalent escape codes, assuming it starts with the default style.
+Codes that do not change the style are removed and adjacent codes are merged into one sequence,
+e.g. "\033[0m\033[0m\033[31m\033[31mtext" becomes "\033[31mtext".
+Escape sequences other than SGR, as well as SGR sequences with unknown parameters, are kept as they are.
+As the style is not fully known after an unknown parameter, SGR sequences are kept as well until the next reset.
+*/
+func Minimize(text string) string {
+	w := sequenceWriter{cfg: minimizer, p: Extended, known: true}
+	unknown := false // The style contains attributes set by unknown parameters
+
+	for i := 0; i < len(text); {
+		if !strings.HasPrefix(text[i:], "\033[") {
+			_, size := utf8.DecodeRuneInString(text[i:])
+			w.raw(text[i : i+size])
+			i += size
+			continue
+		}
+
+		end := i + 2
+		for end < len(text) && (text[end] < 0x40 || text[end] > 0x7e) {
+			end++
+		}
+		if end == len(text) {
+			w.raw(text[i:])
+			break
+		}
+
+		seq := text[i : end+1]
+		i = end + 1
+		if seq[len(seq)-1] != 'm' {
+			w.raw(seq)
+			continue
+		}
+
+		codes, known, clean := parseSGR(seq[2 : len(seq)-1])
+		if known && !unknown {
+			w.code(codes...)
+			continue
+		}
+		w.raw(seq)
+		reset := false
+		for _, c := range codes {
+			w.s.apply(c)
+			reset = reset || c == Reset
+		}
+		w.out = w.s
+		unknown = !clean || (unknown && !reset)
+	}
+
+	w.flush()
+	return string(w.b)
+}
+
+/*
+parseSGR returns the Colors of the known parameters of an SGR sequence, skipping unknown ones,
+and whether all parameters are known.
+clean reports whether no unknown parameter follows the last reset, so the style after the sequence is known if it was before.
+*/
+func parseSGR(params string) (codes []Color, known, clean bool) {
+	known, clean = true, true
+	fields := strings.Split(params, ";")
+
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		if n, err := strconv.Atoi(field); err == nil {
+			field = strconv.Itoa(n)
+		} else if field == "" {
+			field = "0"
+		}
+
+		if field == "38" || field == "48" || field == "58" {
+			c, n := parseExtendedColor(fields[i+1:])
+			if c == invalidColor {
+				// The number of fields belonging to the color is unknown, so the rest cannot be parsed.
+				return codes, false, false
+			}
+			codes = append(codes, extendedLayer(field, c))
+			i += n
+			continue
+		}
+		if layer, sub, ok := strings.Cut(field, ":"); ok && (layer == "38" || layer == "48" || layer == "58") {
+			subs := strings.Split(sub, ":")
+			if len(subs) == 5 && subs[0] == "2" {
+				// The color space ID in 38:2:<id>:r:g:b is ignored
+				subs = append(subs[:1], subs[2:]...)
+			}
+			c, n := parseExtendedColor(subs)
+			if c == invalidColor || n != len(subs) {
+				known, clean = false, false
+				continue
+			}
+			codes = append(codes, extendedLayer(layer, c))
+			continue
+		}
+
+		c, ok := sgrCodes[field]
+		if !ok {
+			known, clean = false, false
+			continue
+		}
+		if c == Reset {
+			clean = true
+		}
+		codes = append(codes, c)
+	}
+	return codes, known, clean
+}
+
+// parseExtendedColor parses the "5;n" or "2;r;g;b" following 38, 48 or 58 and returns the number of fields used.
+func parseExtendedColor(fields []string) (Color, int) {
+	var v [3]uint8
+	n := 0
+	switch {
+	case len(fields) >= 2 && fields[0] == "5":
+		n = 1
+	case len(fields) >= 4 && fields[0] == "2":
+		n = 3
+	default:
+		return invalidColor, 0
+	}
+
+	for i := 0; i < n; i++ {
+		x, err := strconv.ParseUint(fields[i+1], 10, 8)
+		if err != nil {
+			return invalidColor, 0
+		}
+		v[i] = uint8(x)
+	}
+	if n == 1 {
+		return Color256(v[0]), 2
+	}
+	return RGB(v[0], v[1], v[2]), 4
+}
+
+// extendedLayer applies an extended foreground color to the layer selected by 38, 48 or 58.
+func extendedLayer(layer string, c Color) Color {
+	switch layer {
+	case "48":
+		return c | bgColor
+	case "58":
+		return c | ulColor
+	default:
+		return c
+	}
+}
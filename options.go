@@ -0,0 +1,215 @@
+package termcol
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrKeyConflict is returned by New if the key and the reset key are the same character.
+var ErrKeyConflict = errors.New("termcol: key and reset key must be different")
+
+// Option configures a Formatter created by New. Unlike the setters, options return an error for invalid values.
+type Option func(cfg *config) error
+
+/*
+New creates a new Formatter with the default settings changed by the options, which are applied in order.
+It returns the first error of an option, or ErrKeyConflict if the key and the reset key are the same.
+This makes it possible to set up a Formatter in a single expression, e.g. in a package-level variable with MustNew.
+*/
+func New(opts ...Option) (*Formatter, error) {
+	cfg := defaultConfig()
+	for _, opt := range opts {
+		if err := opt(cfg); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.key == cfg.resetKey {
+		return nil, ErrKeyConflict
+	}
+	cfg.detect()
+
+	f := &Formatter{}
+	f.cfg.Store(cfg)
+	return f, nil
+}
+
+// MustNew is like New, but panics if an option is invalid.
+func MustNew(opts ...Option) *Formatter {
+	f, err := New(opts...)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// WithKey sets the key for colorization. (Default: '&')
+// Reserved runes (space, newline, '{' and '!') return an error.
+func WithKey(k rune) Option {
+	return func(cfg *config) error {
+		if !validKey(k) {
+			return fmt.Errorf("termcol: invalid key %q", k)
+		}
+		cfg.key = k
+		return nil
+	}
+}
+
+// WithResetKey sets the key for resetting colorization. (Default: '§')
+// Reserved runes (space, newline, '{' and '!') return an error.
+func WithResetKey(k rune) Option {
+	return func(cfg *config) error {
+		if !validKey(k) {
+			return fmt.Errorf("termcol: invalid reset key %q", k)
+		}
+		cfg.resetKey = k
+		return nil
+	}
+}
+
+// WithResetAtEnd sets whether the reset key is applied at the end of the text. (Default: true)
+func WithResetAtEnd(b bool) Option {
+	return func(cfg *config) error {
+		cfg.resetAtEnd = b
+		return nil
+	}
+}
+
+// WithResetBeforeNewline sets whether the reset key is applied before newlines. (Default: true)
+func WithResetBeforeNewline(b bool) Option {
+	return func(cfg *config) error {
+		cfg.resetBeforeNewline = b
+		return nil
+	}
+}
+
+// WithCombineSequences sets whether adjacent codes are merged into one escape sequence. (Default: true)
+func WithCombineSequences(b bool) Option {
+	return func(cfg *config) error {
+		cfg.combineSequences = b
+		return nil
+	}
+}
+
+// WithRestoreAfterNewline sets whether the active style is applied again after newlines. (Default: false)
+func WithRestoreAfterNewline(b bool) Option {
+	return func(cfg *config) error {
+		cfg.restoreNewline = b
+		return nil
+	}
+}
+
+// WithTargetedResets sets whether resets only turn off the attributes and colors that were set. (Default: false)
+func WithTargetedResets(b bool) Option {
+	return func(cfg *config) error {
+		cfg.targetedResets = b
+		return nil
+	}
+}
+
+// WithStyleStack sets whether the reset key restores the style from before the last group of keys. (Default: false)
+func WithStyleStack(b bool) Option {
+	return func(cfg *config) error {
+		cfg.styleStack = b
+		return nil
+	}
+}
+
+// WithErrorMode sets how invalid format strings are handled. (Default: ErrorInline)
+func WithErrorMode(m ErrorMode) Option {
+	return func(cfg *config) error {
+		if m < ErrorInline || m > ErrorCallback {
+			return fmt.Errorf("termcol: invalid error mode %d", m)
+		}
+		cfg.errorMode = m
+		return nil
+	}
+}
+
+// WithErrorCallback sets the error mode to ErrorCallback, passing every ParseError to fn.
+func WithErrorCallback(fn func(ParseError)) Option {
+	return func(cfg *config) error {
+		if fn == nil {
+			return errors.New("termcol: error callback is nil")
+		}
+		cfg.errorMode = ErrorCallback
+		cfg.errorCallback = fn
+		return nil
+	}
+}
+
+// WithProfile sets the color profile. (Default: Auto)
+func WithProfile(p Profile) Option {
+	return func(cfg *config) error {
+		if p < Auto || p > Extended {
+			return fmt.Errorf("termcol: invalid profile %d", p)
+		}
+		cfg.profile = p
+		return nil
+	}
+}
+
+// WithOutput sets the writer the print functions write to, which is also used to detect the profile. (Default: os.Stdout)
+func WithOutput(w io.Writer) Option {
+	return func(cfg *config) error {
+		if w == nil {
+			return errors.New("termcol: output is nil")
+		}
+		cfg.output = w
+		return nil
+	}
+}
+
+// WithDiagnosticOutput sets the writer for status messages of LevelWarning and above. (Default: os.Stderr)
+func WithDiagnosticOutput(w io.Writer) Option {
+	return func(cfg *config) error {
+		if w == nil {
+			return errors.New("termcol: diagnostic output is nil")
+		}
+		cfg.diagnostic = w
+		return nil
+	}
+}
+
+// StatusStyle is the color and prefix text of a status message, as set by SetSuccessStyle.
+type StatusStyle struct {
+	Color Color
+	Text  string
+}
+
+// WithStatusStyles sets the styles of success, warning and error messages. (Default: green "Success: ", yellow "Warning: ", red "Error: ")
+func WithStatusStyles(success, warning, err StatusStyle) Option {
+	return func(cfg *config) error {
+		for _, s := range []StatusStyle{success, warning, err} {
+			if !isColorCode(s.Color) {
+				return fmt.Errorf("termcol: invalid status color %d", s.Color)
+			}
+		}
+		cfg.setStyle("success", success.Color, success.Text)
+		cfg.setStyle("warning", warning.Color, warning.Text)
+		cfg.setStyle("error", err.Color, err.Text)
+		return nil
+	}
+}
+
+// WithLevel registers a named level of status messages like SetLevel.
+func WithLevel(name string, lvl Level, color Color, text string) Option {
+	return func(cfg *config) error {
+		if name == "" {
+			return errors.New("termcol: level name is empty")
+		}
+		if !isColorCode(color) {
+			return fmt.Errorf("termcol: invalid color %d of level %q", color, name)
+		}
+		cfg.setLevel(name, level{lvl, color, text})
+		return nil
+	}
+}
+
+// WithMinLevel sets the level below which status messages are discarded. (Default: LevelInfo)
+func WithMinLevel(l Level) Option {
+	return func(cfg *config) error {
+		cfg.minLevel = l
+		return nil
+	}
+}
@@ -0,0 +1,149 @@
+package termcol
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Profile describes which escape codes a terminal supports.
+type Profile int
+
+const (
+	Auto      Profile = iota // Detect the profile from the output and the environment
+	NoColor                  // No escape codes at all
+	ANSI                     // 16 basic colors and text styles
+	ANSI256                  // xterm 256-color palette
+	TrueColor                // 24-bit colors
+	Extended                 // 24-bit colors with styled and colored underlines
+)
+
+// SetProfile sets the color profile used by the Formatter. (Default: Auto)
+// With Auto, the profile of the output is detected using DetectProfile when the Formatter is created or the output is set,
+// which the Sprint functions use as well, as they do not know where the text is written to.
+func (f *Formatter) SetProfile(p Profile) {
+	if p < Auto || p > Extended {
+		return
+	}
+	f.update(func(cfg *config) { cfg.profile = p })
+}
+
+/*
+profileFor returns the profile used for writing to w, or for returning a string if w is nil,
+which uses the profile of the output. The profiles of the outputs are detected when they are set, so only other files are checked for a terminal.
+*/
+func (cfg *config) profileFor(w io.Writer) Profile {
+	if cfg.profile != Auto {
+		return cfg.profile
+	}
+	if w == nil {
+		return cfg.outputProfile
+	}
+
+	file, ok := w.(*os.File)
+	switch {
+	case !ok:
+		return cfg.env.profileFor(false)
+	case w == cfg.output:
+		return cfg.outputProfile
+	case w == cfg.diagnostic:
+		return cfg.diagnosticProfile
+	}
+	return cfg.env.profileFor(isTerminal(file))
+}
+
+// detect detects the environment and the profiles of the outputs.
+func (cfg *config) detect() {
+	cfg.env = detectEnvironment()
+	cfg.outputProfile = cfg.env.profileFor(isTerminal(cfg.output))
+	cfg.diagnosticProfile = cfg.env.profileFor(isTerminal(cfg.diagnostic))
+}
+
+/*
+DetectProfile returns the color profile supported when writing to w.
+The environment variables are checked in the following order:
+NO_COLOR disables all colors.
+FORCE_COLOR ("0" disables, "1" to "3" force ANSI, ANSI256 or TrueColor) and CLICOLOR_FORCE enable colors, even if w is not a terminal.
+CLICOLOR=0 disables colors and TERM=dumb disables them if they are not forced.
+Otherwise, w must be a terminal, and COLORTERM and TERM determine whether ANSI256 or TrueColor is supported.
+Extended is detected for terminals known to support styled underlines, like kitty, WezTerm, iTerm2 and VTE based terminals.
+*/
+func DetectProfile(w io.Writer) Profile {
+	return detectEnvironment().profileFor(isTerminal(w))
+}
+
+// environment is the part of DetectProfile that does not depend on the writer.
+type environment struct {
+	disabled bool    // Colors are disabled, even for terminals
+	forced   Profile // Profile forced for any writer, or NoColor
+	profile  Profile // Profile supported by terminals
+}
+
+// detectEnvironment checks the environment variables described in DetectProfile.
+func detectEnvironment() environment {
+	if os.Getenv("NO_COLOR") != "" {
+		return environment{disabled: true}
+	}
+
+	forced := NoColor
+	if v, ok := os.LookupEnv("FORCE_COLOR"); ok {
+		switch strings.ToLower(v) {
+		case "0", "false":
+			return environment{disabled: true}
+		case "2":
+			forced = ANSI256
+		case "3":
+			forced = TrueColor
+		default:
+			forced = ANSI
+		}
+	} else if v := os.Getenv("CLICOLOR_FORCE"); v != "" && v != "0" {
+		forced = ANSI
+	}
+
+	if forced == NoColor && os.Getenv("CLICOLOR") == "0" {
+		return environment{disabled: true}
+	}
+	return environment{forced: forced, profile: max(envProfile(), forced)}
+}
+
+// profileFor returns the profile for a writer, which is a terminal or not.
+func (e environment) profileFor(terminal bool) Profile {
+	if e.disabled || (e.forced == NoColor && !terminal) {
+		return NoColor
+	}
+	return e.profile
+}
+
+// envProfile returns the profile announced by TERM, COLORTERM and terminal specific variables.
+func envProfile() Profile {
+	term := strings.ToLower(os.Getenv("TERM"))
+	colorTerm := strings.ToLower(os.Getenv("COLORTERM"))
+	vte, _ := strconv.Atoi(os.Getenv("VTE_VERSION"))
+
+	switch {
+	case term == "dumb":
+		return NoColor
+	case strings.Contains(term, "kitty"), strings.Contains(term, "wezterm"), os.Getenv("KITTY_WINDOW_ID") != "",
+		os.Getenv("TERM_PROGRAM") == "WezTerm", os.Getenv("TERM_PROGRAM") == "iTerm.app", vte >= 5102:
+		return Extended
+	case colorTerm == "truecolor" || colorTerm == "24bit",
+		strings.Contains(term, "truecolor"), strings.Contains(term, "direct"):
+		return TrueColor
+	case strings.Contains(term, "256color"):
+		return ANSI256
+	default:
+		return ANSI
+	}
+}
+
+// isTerminal reports whether w is a character device, such as a terminal.
+func isTerminal(w io.Writer) bool {
+	file, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
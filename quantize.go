@@ -0,0 +1,219 @@
+package termcol
+
+import "math"
+
+// RGB values of the 16 basic colors, as used by xterm.
+var basicRGB = [16][3]uint8{
+	{0, 0, 0},       // Black
+	{205, 0, 0},     // Red
+	{0, 205, 0},     // Green
+	{205, 205, 0},   // Yellow
+	{0, 0, 238},     // Blue
+	{205, 0, 205},   // Magenta
+	{0, 205, 205},   // Cyan
+	{229, 229, 229}, // White
+	{127, 127, 127}, // Gray
+	{255, 0, 0},     // BrightRed
+	{0, 255, 0},     // BrightGreen
+	{255, 255, 0},   // BrightYellow
+	{92, 92, 255},   // BrightBlue
+	{255, 0, 255},   // BrightMagenta
+	{0, 255, 255},   // BrightCyan
+	{255, 255, 255}, // BrightWhite
+}
+
+// Channel values of the 6x6x6 color cube in the 256-color palette.
+var cubeLevels = [6]uint8{0, 95, 135, 175, 215, 255}
+
+// Lab values of the basic colors and of the palette entries 16-255, used for nearest color searches.
+var (
+	basicLab   [16]lab
+	paletteLab [256]lab
+)
+
+func init() {
+	for i, c := range basicRGB {
+		basicLab[i] = toLab(c[0], c[1], c[2])
+	}
+	for i := 16; i < 256; i++ {
+		r, g, b := paletteRGB(uint8(i))
+		paletteLab[i] = toLab(r, g, b)
+	}
+}
+
+// downsample maps an extended color to the nearest color supported by the profile p.
+func downsample(p Profile, c Color) Color {
+	if p >= TrueColor || c&(rgbColor|paletteColor) == 0 {
+		return c
+	}
+
+	bg := c & bgColor
+	if c&rgbColor != 0 {
+		r, g, b := uint8(c>>16), uint8(c>>8), uint8(c)
+		if p == ANSI256 {
+			return paletteColor | bg | Color(nearestPalette(r, g, b))
+		}
+		return basicColor(nearestBasic(r, g, b), bg != 0)
+	}
+
+	if p == ANSI256 {
+		return c
+	}
+	n := uint8(c)
+	if n < 16 {
+		return basicColor(int(n), bg != 0)
+	}
+	r, g, b := paletteRGB(n)
+	return basicColor(nearestBasic(r, g, b), bg != 0)
+}
+
+// basicColor returns the Color of the n-th basic color.
+func basicColor(n int, bg bool) Color {
+	if bg {
+		return BlackBg + Color(n)
+	}
+	return Black + Color(n)
+}
+
+// paletteRGB returns the RGB value of an entry of the 256-color palette.
+func paletteRGB(n uint8) (r, g, b uint8) {
+	switch {
+	case n < 16:
+		c := basicRGB[n]
+		return c[0], c[1], c[2]
+	case n < 232:
+		n -= 16
+		return cubeLevels[n/36], cubeLevels[n/6%6], cubeLevels[n%6]
+	default:
+		v := 8 + 10*(n-232)
+		return v, v, v
+	}
+}
+
+// nearestPalette returns the entry of the 256-color palette closest to the given color.
+// The basic colors 0-15 are skipped, as their values differ between terminals.
+func nearestPalette(r, g, b uint8) uint8 {
+	target := toLab(r, g, b)
+	best, bestDist := 16, math.Inf(1)
+	for i := 16; i < 256; i++ {
+		if d := target.dist(paletteLab[i]); d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return uint8(best)
+}
+
+// nearestBasic returns the index of the basic color closest to the given color.
+func nearestBasic(r, g, b uint8) int {
+	target := toLab(r, g, b)
+	best, bestDist := 0, math.Inf(1)
+	for i := range basicLab {
+		if d := target.dist(basicLab[i]); d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best
+}
+
+// lab is a color in the CIELAB color space.
+type lab struct {
+	l, a, b float64
+}
+
+// dist returns the squared CIEDE2000 color difference of two colors.
+func (c lab) dist(o lab) float64 {
+	const pow25to7 = 6103515625.0
+
+	c1, c2 := math.Hypot(c.a, c.b), math.Hypot(o.a, o.b)
+	cMean7 := math.Pow((c1+c2)/2, 7)
+	g := 0.5 * (1 - math.Sqrt(cMean7/(cMean7+pow25to7)))
+
+	a1, a2 := (1+g)*c.a, (1+g)*o.a
+	c1, c2 = math.Hypot(a1, c.b), math.Hypot(a2, o.b)
+	h1, h2 := hue(c.b, a1), hue(o.b, a2)
+
+	dL := o.l - c.l
+	dC := c2 - c1
+	dh := 0.0
+	if c1*c2 != 0 {
+		dh = h2 - h1
+		if dh > 180 {
+			dh -= 360
+		} else if dh < -180 {
+			dh += 360
+		}
+	}
+	dH := 2 * math.Sqrt(c1*c2) * math.Sin(rad(dh/2))
+
+	lMean := (c.l + o.l) / 2
+	cMean := (c1 + c2) / 2
+	hMean := h1 + h2
+	if c1*c2 != 0 {
+		switch {
+		case math.Abs(h1-h2) <= 180:
+			hMean /= 2
+		case h1+h2 < 360:
+			hMean = (hMean + 360) / 2
+		default:
+			hMean = (hMean - 360) / 2
+		}
+	}
+
+	t := 1 - 0.17*math.Cos(rad(hMean-30)) + 0.24*math.Cos(rad(2*hMean)) +
+		0.32*math.Cos(rad(3*hMean+6)) - 0.20*math.Cos(rad(4*hMean-63))
+	dTheta := 30 * math.Exp(-math.Pow((hMean-275)/25, 2))
+	cMean7 = math.Pow(cMean, 7)
+	rC := 2 * math.Sqrt(cMean7/(cMean7+pow25to7))
+	l50 := (lMean - 50) * (lMean - 50)
+	sL := 1 + 0.015*l50/math.Sqrt(20+l50)
+	sC := 1 + 0.045*cMean
+	sH := 1 + 0.015*cMean*t
+	rT := -math.Sin(rad(2*dTheta)) * rC
+
+	dL, dC, dH = dL/sL, dC/sC, dH/sH
+	return dL*dL + dC*dC + dH*dH + rT*dC*dH
+}
+
+// hue returns the hue angle in degrees in the range [0, 360).
+func hue(b, a float64) float64 {
+	if a == 0 && b == 0 {
+		return 0
+	}
+	h := math.Atan2(b, a) * 180 / math.Pi
+	if h < 0 {
+		h += 360
+	}
+	return h
+}
+
+func rad(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+// toLab converts an sRGB color to CIELAB using the D65 white point.
+func toLab(r, g, b uint8) lab {
+	lr, lg, lb := linear(r), linear(g), linear(b)
+
+	x := (0.4124564*lr + 0.3575761*lg + 0.1804375*lb) / 0.95047
+	y := 0.2126729*lr + 0.7151522*lg + 0.0721750*lb
+	z := (0.0193339*lr + 0.1191920*lg + 0.9503041*lb) / 1.08883
+
+	fx, fy, fz := labF(x), labF(y), labF(z)
+	return lab{116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)}
+}
+
+// linear converts an sRGB channel to linear light.
+func linear(v uint8) float64 {
+	c := float64(v) / 255
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+func labF(t float64) float64 {
+	if t > 216.0/24389 {
+		return math.Cbrt(t)
+	}
+	return (24389.0/27*t + 16) / 116
+}
@@ -0,0 +1,7 @@
+//go:build race
+
+package termcol
+
+func init() {
+	raceEnabled = true
+}
@@ -0,0 +1,252 @@
+package termcol
+
+// attrs is a set of text attributes that are turned on and off independently.
+type attrs uint8
+
+const (
+	attrBold attrs = 1 << iota
+	attrDim
+	attrItalic
+	attrBlink
+	attrReverse
+	attrHidden
+	attrStrike
+	attrOverline
+)
+
+// Mapping attribute Colors to the attributes they turn on
+var attrOn = map[Color]attrs{
+	Bold:          attrBold,
+	Dim:           attrDim,
+	Italic:        attrItalic,
+	Blink:         attrBlink,
+	Reverse:       attrReverse,
+	Hidden:        attrHidden,
+	StrikeThrough: attrStrike,
+	Overline:      attrOverline,
+}
+
+// Mapping attribute Colors to the attributes they turn off
+var attrOff = map[Color]attrs{
+	BoldOff:          attrBold | attrDim,
+	ItalicOff:        attrItalic,
+	BlinkOff:         attrBlink,
+	ReverseOff:       attrReverse,
+	HiddenOff:        attrHidden,
+	StrikeThroughOff: attrStrike,
+	OverlineOff:      attrOverline,
+}
+
+// state is the effective style of the terminal after a sequence of Colors.
+// Zero values stand for the terminal defaults.
+type state struct {
+	fg        Color
+	bg        Color
+	ul        Color // Underline color
+	underline Color // Underline style
+	attrs     attrs
+}
+
+// apply updates the state with the effect of c.
+func (s *state) apply(c Color) {
+	switch {
+	case c == Reset:
+		*s = state{}
+	case c&ulColor != 0:
+		s.ul = c
+	case c&bgColor != 0, c >= BlackBg && c <= BrightWhiteBg:
+		s.bg = c
+	case c&(rgbColor|paletteColor) != 0, c >= Black && c <= BrightWhite:
+		s.fg = c
+	case c == Underline, c == DoubleUnderline, c >= CurlyUnderline && c <= DashedUnderline:
+		s.underline = c
+	case c == UnderlineOff:
+		s.underline = 0
+	case c == DefaultFg:
+		s.fg = 0
+	case c == DefaultBg:
+		s.bg = 0
+	case c == DefaultUnderlineColor:
+		s.ul = 0
+	default:
+		s.attrs |= attrOn[c]
+		s.attrs &^= attrOff[c]
+	}
+}
+
+// off returns the codes turning off everything set in the state.
+func (s state) off() []Color {
+	var codes []Color
+	if s.attrs&(attrBold|attrDim) != 0 {
+		codes = append(codes, BoldOff)
+	}
+	if s.attrs&attrItalic != 0 {
+		codes = append(codes, ItalicOff)
+	}
+	if s.underline != 0 {
+		codes = append(codes, UnderlineOff)
+	}
+	for _, c := range []Color{BlinkOff, ReverseOff, HiddenOff, StrikeThroughOff, OverlineOff} {
+		if s.attrs&attrOff[c] != 0 {
+			codes = append(codes, c)
+		}
+	}
+	if s.fg != 0 {
+		codes = append(codes, DefaultFg)
+	}
+	if s.bg != 0 {
+		codes = append(codes, DefaultBg)
+	}
+	if s.ul != 0 {
+		codes = append(codes, DefaultUnderlineColor)
+	}
+	return codes
+}
+
+// diff returns the codes changing the state s to t.
+func (s state) diff(t state) []Color {
+	return s.appendDiff(nil, t)
+}
+
+// appendDiff appends the codes changing the state s to t to codes.
+func (s state) appendDiff(codes []Color, t state) []Color {
+	if s.attrs&^t.attrs&(attrBold|attrDim) != 0 {
+		codes = append(codes, BoldOff)
+		s.attrs &^= attrBold | attrDim
+	}
+	if s.attrs&^t.attrs != 0 {
+		for _, c := range []Color{ItalicOff, BlinkOff, ReverseOff, HiddenOff, StrikeThroughOff, OverlineOff} {
+			if s.attrs&^t.attrs&attrOff[c] != 0 {
+				codes = append(codes, c)
+			}
+		}
+	}
+	if s.underline != t.underline {
+		if t.underline == 0 {
+			codes = append(codes, UnderlineOff)
+		} else {
+			codes = append(codes, t.underline)
+		}
+	}
+	if t.attrs&^s.attrs != 0 {
+		for _, c := range []Color{Bold, Dim, Italic, Blink, Reverse, Hidden, StrikeThrough, Overline} {
+			if t.attrs&^s.attrs&attrOn[c] != 0 {
+				codes = append(codes, c)
+			}
+		}
+	}
+
+	layers := [][3]Color{{s.fg, t.fg, DefaultFg}, {s.bg, t.bg, DefaultBg}, {s.ul, t.ul, DefaultUnderlineColor}}
+	for _, l := range layers {
+		if l[0] == l[1] {
+			continue
+		}
+		if l[1] == 0 {
+			codes = append(codes, l[2])
+		} else {
+			codes = append(codes, l[1])
+		}
+	}
+	return codes
+}
+
+/*
+Style is a combination of colors and text attributes, built by chaining methods.
+Example: NewStyle().Fg(Red).Bg(BlueBg).Bold().Render("text") renders "text" in bold red on blue.
+Styles are values, so every method returns a modified copy.
+*/
+type Style struct {
+	s state
+}
+
+// NewStyle returns a Style with the given colors and attributes applied in order. Invalid colors are ignored.
+func NewStyle(colors ...Color) Style {
+	return Style{}.Add(colors...)
+}
+
+// Add returns the style with the given colors and attributes applied in order. Invalid colors are ignored.
+func (st Style) Add(colors ...Color) Style {
+	for _, c := range colors {
+		if isColorCode(c) {
+			st.s.apply(c)
+		}
+	}
+	return st
+}
+
+// Fg returns the style with the foreground color c. Background, underline and invalid colors are ignored.
+func (st Style) Fg(c Color) Style {
+	if c >= Black && c <= BrightWhite || isColorCode(c) && c&(rgbColor|paletteColor) != 0 && c&(bgColor|ulColor) == 0 {
+		st.s.fg = c
+	}
+	return st
+}
+
+// Bg returns the style with the background color c, which may also be given as a foreground color.
+func (st Style) Bg(c Color) Style {
+	if isColorCode(c) && c&ulColor == 0 {
+		if bg := background(c); bg != invalidColor {
+			st.s.bg = bg
+		}
+	}
+	return st
+}
+
+// UnderlineColor returns the style with the underline color c, which may also be given as a foreground color.
+func (st Style) UnderlineColor(c Color) Style {
+	if isColorCode(c) {
+		if ul := underline(c); ul != invalidColor {
+			st.s.ul = ul
+		}
+	}
+	return st
+}
+
+// Bold returns the style with bold text.
+func (st Style) Bold() Style { return st.Add(Bold) }
+
+// Dim returns the style with dim text.
+func (st Style) Dim() Style { return st.Add(Dim) }
+
+// Italic returns the style with italic text.
+func (st Style) Italic() Style { return st.Add(Italic) }
+
+// Underline returns the style with underlined text.
+func (st Style) Underline() Style { return st.Add(Underline) }
+
+// DoubleUnderline returns the style with double underlined text.
+func (st Style) DoubleUnderline() Style { return st.Add(DoubleUnderline) }
+
+// CurlyUnderline returns the style with curly underlined text.
+func (st Style) CurlyUnderline() Style { return st.Add(CurlyUnderline) }
+
+// DottedUnderline returns the style with dotted underlined text.
+func (st Style) DottedUnderline() Style { return st.Add(DottedUnderline) }
+
+// DashedUnderline returns the style with dashed underlined text.
+func (st Style) DashedUnderline() Style { return st.Add(DashedUnderline) }
+
+// Blink returns the style with blinking text.
+func (st Style) Blink() Style { return st.Add(Blink) }
+
+// Reverse returns the style with swapped foreground and background colors.
+func (st Style) Reverse() Style { return st.Add(Reverse) }
+
+// Hidden returns the style with hidden text.
+func (st Style) Hidden() Style { return st.Add(Hidden) }
+
+// StrikeThrough returns the style with struck through text.
+func (st Style) StrikeThrough() Style { return st.Add(StrikeThrough) }
+
+// Overline returns the style with overlined text.
+func (st Style) Overline() Style { return st.Add(Overline) }
+
+// Codes returns the colors and attributes setting the style, e.g. to be passed to Sprintc.
+func (st Style) Codes() []Color {
+	return state{}.diff(st.s)
+}
+
+// Render is a Wrapper for defaultFormatter.Render (Further information in Formatter.Render)
+func (st Style) Render(text string) string {
+	return df.Render(st, text)
+}
@@ -0,0 +1,101 @@
+package termcol
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+/*
+Template is a format string compiled by Formatter.Compile, with the color keys already replaced by escape codes.
+It keeps the settings of the Formatter at the time of compilation and is safe for concurrent use.
+*/
+type Template struct {
+	cfg     *config
+	layouts [Extended + 1]string // Compiled format string for each profile
+	last    atomic.Pointer[fileProfile]
+}
+
+// fileProfile is the profile detected for a file, which Template remembers for the last file written to.
+type fileProfile struct {
+	file    *os.File
+	profile Profile
+}
+
+/*
+Compile parses the format string once and returns a Template to format it with different arguments,
+which is faster than calling Sprintf with the same format string repeatedly.
+Unlike Sprintf, errors are returned instead of being handled according to the error mode.
+*/
+func (f *Formatter) Compile(text string) (*Template, error) {
+	cfg := f.load()
+	t := &Template{cfg: cfg}
+	for p := NoColor; p <= Extended; p++ {
+		layout, err := format(cfg, p, text)
+		if err != nil {
+			return nil, err
+		}
+		t.layouts[p] = layout
+	}
+	return t, nil
+}
+
+// MustCompile is like Compile, but panics with the ParseError if the format string is invalid.
+func (f *Formatter) MustCompile(text string) *Template {
+	t, err := f.Compile(text)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// layout returns the compiled format string for writing to w, or for returning a string if w is nil.
+func (t *Template) layout(w io.Writer) string {
+	file, ok := w.(*os.File)
+	if !ok {
+		return t.layouts[t.cfg.profileFor(w)]
+	}
+
+	// Avoid checking for a terminal on every write to the same file.
+	if last := t.last.Load(); last != nil && last.file == file {
+		return t.layouts[last.profile]
+	}
+	p := t.cfg.profileFor(w)
+	t.last.Store(&fileProfile{file, p})
+	return t.layouts[p]
+}
+
+// Sprintf formats the template with the arguments like Formatter.Sprintf and returns the result.
+func (t *Template) Sprintf(a ...any) string {
+	if len(a) == 0 {
+		return t.layout(nil)
+	}
+	return fmt.Sprintf(t.layout(nil), a...)
+}
+
+// Fprintf formats the template with the arguments like Formatter.Fprintf and writes it to w.
+func (t *Template) Fprintf(w io.Writer, a ...any) (int, error) {
+	if len(a) == 0 {
+		return io.WriteString(w, t.layout(w))
+	}
+	return fmt.Fprintf(w, t.layout(w), a...)
+}
+
+// Append formats the template with the arguments like Sprintf and appends the result to b.
+func (t *Template) Append(b []byte, a ...any) []byte {
+	if len(a) == 0 {
+		return append(b, t.layout(nil)...)
+	}
+	return fmt.Appendf(b, t.layout(nil), a...)
+}
+
+// Compile is a Wrapper for defaultFormatter.Compile (Further information in Formatter.Compile)
+func Compile(text string) (*Template, error) {
+	return df.Compile(text)
+}
+
+// MustCompile is a Wrapper for defaultFormatter.MustCompile (Further information in Formatter.MustCompile)
+func MustCompile(text string) *Template {
+	return df.MustCompile(text)
+}
@@ -4,87 +4,244 @@ package termcol
 import (
 	"fmt"
 	"io"
+	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
 )
 
 // Default Formatter instance used for formatting.
 var df = NewFormatter()
 
-// Formatter configures the different formatting options for terminal output.
+/*
+Formatter configures the different formatting options for terminal output.
+It is safe for concurrent use: every call works on a snapshot of the settings,
+and changing a setting replaces the snapshot with a modified copy.
+*/
 type Formatter struct {
+	mu  sync.Mutex // Serializes changes of the settings
+	cfg atomic.Pointer[config]
+}
+
+// config holds the settings of a Formatter, which are never modified once it is stored.
+type config struct {
 	key                rune
 	resetKey           rune
 	resetAtEnd         bool
 	resetBeforeNewline bool
-	successText        string
-	warningText        string
-	errorText          string
-	successColor       colorCode
-	warningColor       colorCode
-	errorColor         colorCode
+	targetedResets     bool
+	styleStack         bool
+	restoreNewline     bool
+	combineSequences   bool
+	errorMode          ErrorMode
+	errorCallback      func(ParseError)
+	levels             map[string]level
+	minLevel           Level
+	profile            Profile
+	output             io.Writer
+	diagnostic         io.Writer
+	env                environment // Detected when the outputs are set
+	outputProfile      Profile     // Profile detected for output
+	diagnosticProfile  Profile     // Profile detected for diagnostic
 }
 
 // NewFormatter creates a new Formatter with the default settings and returns it.
 func NewFormatter() *Formatter {
-	return &Formatter{
+	f := &Formatter{}
+	cfg := defaultConfig()
+	cfg.detect()
+	f.cfg.Store(cfg)
+	return f
+}
+
+// defaultConfig returns the default settings of a new Formatter.
+func defaultConfig() *config {
+	return &config{
 		key:                '&',
 		resetKey:           '§',
 		resetAtEnd:         true,
 		resetBeforeNewline: true,
-		successText:        "Success: ",
-		warningText:        "Warning: ",
-		errorText:          "Error: ",
-		warningColor:       Yellow,
-		successColor:       Green,
-		errorColor:         Red,
+		combineSequences:   true,
+		levels:             defaultLevels,
+		output:             os.Stdout,
+		diagnostic:         os.Stderr,
+	}
+}
+
+// Clone returns a new Formatter with the current settings of f, which can be changed independently.
+func (f *Formatter) Clone() *Formatter {
+	clone := &Formatter{}
+	clone.cfg.Store(f.load())
+	return clone
+}
+
+// load returns the current settings, which must not be modified.
+func (f *Formatter) load() *config {
+	if cfg := f.cfg.Load(); cfg != nil {
+		return cfg
 	}
+	return zeroConfig()
+}
+
+// zeroConfig returns the settings of the zero Formatter, which only has the outputs and levels set.
+var zeroConfig = sync.OnceValue(func() *config {
+	cfg := &config{output: os.Stdout, diagnostic: os.Stderr, levels: defaultLevels}
+	cfg.detect()
+	return cfg
+})
+
+// update stores a copy of the current settings changed by fn.
+func (f *Formatter) update(fn func(cfg *config)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cfg := *f.load()
+	fn(&cfg)
+	f.cfg.Store(&cfg)
 }
 
 // SetKey sets the key for colorization in the Formatter. (Default: '&')
+// Reserved runes (space, newline, '{' and '!') and the reset key are ignored.
 func (f *Formatter) SetKey(k rune) {
-	f.key = k
+	if !validKey(k) {
+		return
+	}
+	f.update(func(cfg *config) {
+		if k != cfg.resetKey {
+			cfg.key = k
+		}
+	})
 }
 
 // SetResetKey sets the key for resetting colorization in the Formatter. (Default: '§')
+// Reserved runes (space, newline, '{' and '!') and the key are ignored.
 func (f *Formatter) SetResetKey(k rune) {
-	f.resetKey = k
+	if !validKey(k) {
+		return
+	}
+	f.update(func(cfg *config) {
+		if k != cfg.key {
+			cfg.resetKey = k
+		}
+	})
+}
+
+// validKey reports whether k can be used as a key, which excludes runes with a meaning to the parser.
+func validKey(k rune) bool {
+	switch k {
+	case ' ', '\n', '{', '!':
+		return false
+	}
+	return utf8.ValidRune(k)
 }
 
 // ResetAtEnd sets whether the reset key should be automatically applied at the end of the text. (Default: true)
 func (f *Formatter) ResetAtEnd(b bool) {
-	f.resetAtEnd = b
+	f.update(func(cfg *config) { cfg.resetAtEnd = b })
 }
 
 // ResetBeforeNewline sets whether the reset key should be automatically applied before newlines. (Default: true)
 func (f *Formatter) ResetBeforeNewline(b bool) {
-	f.resetBeforeNewline = b
+	f.update(func(cfg *config) { cfg.resetBeforeNewline = b })
+}
+
+/*
+CombineSequences sets whether adjacent codes are merged into one escape sequence,
+leaving out codes that do not change the current style. (Default: true)
+As the text may follow other output, resets and off codes are kept until the text contains a full reset.
+Disabling it writes every code separately, byte for byte as given.
+*/
+func (f *Formatter) CombineSequences(b bool) {
+	f.update(func(cfg *config) { cfg.combineSequences = b })
+}
+
+/*
+RestoreAfterNewline sets whether the active style is reset before newlines and applied again after them. (Default: false)
+This makes every line self-contained, so colors neither bleed into the margin of pagers nor get lost after the first line.
+*/
+func (f *Formatter) RestoreAfterNewline(b bool) {
+	f.update(func(cfg *config) { cfg.restoreNewline = b })
+}
+
+/*
+TargetedResets sets whether resets only turn off the attributes and colors set before, instead of everything. (Default: false)
+This applies to the reset key, Reset arguments and the automatic resets at the end of the text and before newlines,
+so the formatted text keeps the style of any surrounding text.
+*/
+func (f *Formatter) TargetedResets(b bool) {
+	f.update(func(cfg *config) { cfg.targetedResets = b })
+}
+
+/*
+StyleStack sets whether the reset key restores the style from before the last group of adjacent keys,
+instead of resetting everything. (Default: false)
+Resetting restores the previous style with the fewest codes, so nested fragments like "&rThe &Fbold§ word is red§" compose.
+If no style is left to restore, the reset key resets everything.
+*/
+func (f *Formatter) StyleStack(b bool) {
+	f.update(func(cfg *config) { cfg.styleStack = b })
+}
+
+// SetErrorMode sets how invalid format strings and colors are handled. (Default: ErrorInline)
+func (f *Formatter) SetErrorMode(m ErrorMode) {
+	if m < ErrorInline || m > ErrorCallback {
+		return
+	}
+	f.update(func(cfg *config) { cfg.errorMode = m })
+}
+
+// SetErrorCallback sets the error mode to ErrorCallback, passing every ParseError to fn.
+func (f *Formatter) SetErrorCallback(fn func(ParseError)) {
+	f.update(func(cfg *config) {
+		cfg.errorMode = ErrorCallback
+		cfg.errorCallback = fn
+	})
 }
 
 // SetSuccessStyle sets the style for success messages in the Formatter. (Default: green "Success: ")
-func (f *Formatter) SetSuccessStyle(color colorCode, text string) {
-	if color < 0 || int(color) >= len(colorValues) {
+func (f *Formatter) SetSuccessStyle(color Color, text string) {
+	if !isColorCode(color) {
 		return
 	}
-	f.successText = text
-	f.successColor = color
+	f.update(func(cfg *config) { cfg.setStyle("success", color, text) })
 }
 
 // SetWarningStyle sets the style for warning messages in the Formatter. (Default: yellow "Warning: ")
-func (f *Formatter) SetWarningStyle(color colorCode, text string) {
-	if color < 0 || int(color) >= len(colorValues) {
+func (f *Formatter) SetWarningStyle(color Color, text string) {
+	if !isColorCode(color) {
 		return
 	}
-	f.warningText = text
-	f.warningColor = color
+	f.update(func(cfg *config) { cfg.setStyle("warning", color, text) })
 }
 
 // SetErrorStyle sets the style for error messages in the Formatter. // (Default: red "Error: ").
-func (f *Formatter) SetErrorStyle(color colorCode, text string) {
-	if color < 0 || int(color) >= len(colorValues) {
+func (f *Formatter) SetErrorStyle(color Color, text string) {
+	if !isColorCode(color) {
 		return
 	}
-	f.errorText = text
-	f.errorColor = color
+	f.update(func(cfg *config) { cfg.setStyle("error", color, text) })
+}
+
+// SetOutput sets the writer the print functions write to, which is also used to detect the profile. (Default: os.Stdout)
+func (f *Formatter) SetOutput(w io.Writer) {
+	if w == nil {
+		return
+	}
+	f.update(func(cfg *config) {
+		cfg.output = w
+		cfg.detect()
+	})
+}
+
+// SetDiagnosticOutput sets the writer for warnings, errors and other status messages of LevelWarning and above. (Default: os.Stderr)
+func (f *Formatter) SetDiagnosticOutput(w io.Writer) {
+	if w == nil {
+		return
+	}
+	f.update(func(cfg *config) {
+		cfg.diagnostic = w
+		cfg.detect()
+	})
 }
 
 /*
@@ -92,28 +249,46 @@ Sprintc returns a formatted string using the provided formatting options.
 '&' is used as the formatting key, '§' resets the formatting.
 Example: Sprintc("& &red-bold §text", termcol.Red, termcol.Bold) will render "red-bold" in red and bold and "text" normally.
 */
-func (f *Formatter) Sprintc(text string, colors ...colorCode) string {
-	text = colorize(f, text, colors)
+func (f *Formatter) Sprintc(text string, colors ...Color) string {
+	cfg := f.load()
+	text = sprintc(cfg, cfg.profileFor(nil), text, colors)
 	return text
 }
 
-// Printc formats the text using Sprintc and prints it to stdout.
-func (f *Formatter) Printc(text string, colors ...colorCode) int {
-	text = f.Sprintc(text, colors...)
-	i, _ := fmt.Print(text)
-	return i
+// SprintcE is like Sprintc, but returns a ParseError instead of writing error messages into the text.
+func (f *Formatter) SprintcE(text string, colors ...Color) (string, error) {
+	cfg := f.load()
+	text, err := colorize(cfg, cfg.profileFor(nil), text, colors)
+	if err != nil {
+		return "", err
+	}
+	return text, nil
+}
+
+// AppendSprintc is like Sprintc, but appends the result to dst and returns the extended buffer.
+func (f *Formatter) AppendSprintc(dst []byte, text string, colors ...Color) []byte {
+	cfg := f.load()
+	return appendSprintc(dst, cfg, cfg.profileFor(nil), text, colors)
+}
+
+// Printc formats the text using Sprintc and prints it to the output.
+func (f *Formatter) Printc(text string, colors ...Color) (int, error) {
+	cfg := f.load()
+	text = sprintc(cfg, cfg.profileFor(cfg.output), text, colors)
+	return io.WriteString(cfg.output, text)
 }
 
-// Printlnc formats the text using Sprintc and prints it to stdout ending with a newline.
-func (f *Formatter) Printlnc(text string, colors ...colorCode) int {
-	text = f.Sprintc(text, colors...)
-	i, _ := fmt.Println(text)
-	return i
+// Printlnc formats the text using Sprintc and prints it to the output ending with a newline.
+func (f *Formatter) Printlnc(text string, colors ...Color) (int, error) {
+	cfg := f.load()
+	text = sprintc(cfg, cfg.profileFor(cfg.output), text, colors)
+	return fmt.Fprintln(cfg.output, text)
 }
 
 // Fprintc formats the text using Sprintc and prints it to the provided io.Writer.
-func (f *Formatter) Fprintc(w io.Writer, text string, colors ...colorCode) (int, error) {
-	text = f.Sprintc(text, colors...)
+func (f *Formatter) Fprintc(w io.Writer, text string, colors ...Color) (int, error) {
+	cfg := f.load()
+	text = sprintc(cfg, cfg.profileFor(w), text, colors)
 	i, err := fmt.Fprint(w, text)
 	return i, err
 }
@@ -122,88 +297,122 @@ func (f *Formatter) Fprintc(w io.Writer, text string, colors ...colorCode) (int,
 Sprintf formats the text using placeholders and returns it as a string.
 Placeholders are defined as '%X' for fmt placeholders, and '&X' for formatting placeholders.
 For example, '&r&F%s' will format the following string, provided by the user, in red and bold.
+Background colors are set with '&!X', e.g. '&!r' for a red background.
+Colors can also be written in braces by name, hex value or 256-color palette index, e.g. '&{red}', '&{#ff8800}' or '&{208}',
+and are applied to the background with a 'bg:' prefix, e.g. '&{bg:red}'.
 The '§' character is used to reset the formatting.
 */
 func (f *Formatter) Sprintf(text string, a ...any) string {
-	text = format(f, text)
+	cfg := f.load()
+	return sprintf(cfg, cfg.profileFor(nil), text, a)
+}
+
+// SprintfE is like Sprintf, but returns a ParseError instead of writing error messages into the text.
+func (f *Formatter) SprintfE(text string, a ...any) (string, error) {
+	cfg := f.load()
+	text, err := format(cfg, cfg.profileFor(nil), text)
+	if err != nil {
+		return "", err
+	}
 	if len(a) == 0 {
-		return text
+		return text, nil
 	}
-	return fmt.Sprintf(text, a...)
+	return fmt.Sprintf(text, a...), nil
 }
 
-// Printf formats the text using Sprintf and prints it to stdout.
-func (f *Formatter) Printf(text string, a ...any) int {
-	text = f.Sprintf(text, a...)
-	i, _ := fmt.Print(text)
-	return i
+// AppendSprintf is like Sprintf, but appends the result to dst and returns the extended buffer, like fmt.Appendf.
+func (f *Formatter) AppendSprintf(dst []byte, text string, a ...any) []byte {
+	cfg := f.load()
+	return appendSprintf(dst, cfg, cfg.profileFor(nil), text, a)
 }
 
-// Printlnf formats the text using Sprintf and prints it to stdout ending with a newline.
-func (f *Formatter) Printlnf(text string, a ...any) int {
-	text = f.Sprintf(text, a...)
-	i, _ := fmt.Println(text)
-	return i
+// Printf formats the text using Sprintf and prints it to the output.
+func (f *Formatter) Printf(text string, a ...any) (int, error) {
+	cfg := f.load()
+	text = sprintf(cfg, cfg.profileFor(cfg.output), text, a)
+	return io.WriteString(cfg.output, text)
+}
+
+// Printlnf formats the text using Sprintf and prints it to the output ending with a newline.
+func (f *Formatter) Printlnf(text string, a ...any) (int, error) {
+	cfg := f.load()
+	text = sprintf(cfg, cfg.profileFor(cfg.output), text, a)
+	return fmt.Fprintln(cfg.output, text)
 }
 
 // Fprintf formats the text using Sprintf and prints it to the provided io.Writer.
 func (f *Formatter) Fprintf(w io.Writer, text string, a ...any) (int, error) {
-	text = f.Sprintf(text, a...)
+	cfg := f.load()
+	text = sprintf(cfg, cfg.profileFor(w), text, a)
 	return fmt.Fprint(w, text)
 }
 
-// Successf prints the text to stdout as a success message in green ending with a newline.
-func (f *Formatter) Successf(text string, a ...any) int {
-	text = f.Sprintf(text, a...)
-	if f.resetAtEnd && !strings.Contains(text, "\033[") {
-		text = text + colorValues[Reset]
-	}
-	text = colorValues[f.successColor] + f.successText + text
-	i, _ := fmt.Println(text)
-	return i
+// Successf prints the text to the output as a success message in green ending with a newline.
+func (f *Formatter) Successf(text string, a ...any) (int, error) {
+	return f.Logf("success", text, a...)
 }
 
-// Warningf prints the text to stdout as a warning message in yellow ending with a newline.
-func (f *Formatter) Warningf(text string, a ...any) int {
-	text = f.Sprintf(text, a...)
-	if f.resetAtEnd && !strings.Contains(text, "\033[") {
-		text = text + colorValues[Reset]
-	}
-	text = colorValues[f.warningColor] + f.warningText + text
-	i, _ := fmt.Println(text)
-	return i
+// Warningf prints the text to the diagnostic output as a warning message in yellow ending with a newline.
+func (f *Formatter) Warningf(text string, a ...any) (int, error) {
+	return f.Logf("warning", text, a...)
+}
+
+// Errorf prints the text to the diagnostic output as an error message in red ending with a newline.
+func (f *Formatter) Errorf(text string, a ...any) (int, error) {
+	return f.Logf("error", text, a...)
 }
 
-// Errorf prints the text to stdout as an error message in red ending with a newline.
-func (f *Formatter) Errorf(text string, a ...any) int {
-	text = f.Sprintf(text, a...)
-	if f.resetAtEnd && !strings.Contains(text, "\033[") {
-		text = text + colorValues[Reset]
+/*
+Render returns the text in the given Style. The text is not parsed for keys.
+The style is reset at the end and, with ResetBeforeNewline, applied to every line separately.
+*/
+func (f *Formatter) Render(s Style, text string) string {
+	cfg := f.load()
+	p := cfg.profileFor(nil)
+	start := sequences(cfg, p, transition(cfg, state{}, s.s))
+	if start == "" {
+		return text
+	}
+
+	end := sequences(cfg, p, resetCodes(cfg, s.s))
+	if cfg.resetBeforeNewline {
+		text = strings.ReplaceAll(text, "\n", end+"\n"+start)
 	}
-	text = colorValues[f.errorColor] + f.errorText + text
-	i, _ := fmt.Println(text)
-	return i
+	if !cfg.resetAtEnd {
+		end = ""
+	}
+	return start + text + end
 }
 
 // Global functions
 
 // Sprintc is a Wrapper for defaultFormatter.Sprintc (Further information in Formatter.Sprintc)
-func Sprintc(text string, colors ...colorCode) string {
+func Sprintc(text string, colors ...Color) string {
 	return df.Sprintc(text, colors...)
 }
 
+// SprintcE is a Wrapper for defaultFormatter.SprintcE (Further information in Formatter.SprintcE)
+func SprintcE(text string, colors ...Color) (string, error) {
+	return df.SprintcE(text, colors...)
+}
+
+// AppendSprintc is a Wrapper for defaultFormatter.AppendSprintc (Further information in Formatter.AppendSprintc)
+func AppendSprintc(dst []byte, text string, colors ...Color) []byte {
+	return df.AppendSprintc(dst, text, colors...)
+}
+
 // Printc is a Wrapper for defaultFormatter.Printc (Further information in Formatter.Printc)
-func Printc(text string, colors ...colorCode) int {
+func Printc(text string, colors ...Color) (int, error) {
 	return df.Printc(text, colors...)
 }
 
 // Printlnc is a Wrapper for defaultFormatter.Printlnc (Further information in Formatter.Printlnc)
-func Printlnc(text string, colors ...colorCode) int {
+func Printlnc(text string, colors ...Color) (int, error) {
 	return df.Printlnc(text, colors...)
 }
 
 // Fprintc is a Wrapper for defaultFormatter.Fprintc (Further information in Formatter.Fprintc)
-func Fprintc(w io.Writer, text string, colors ...colorCode) (int, error) {
+func Fprintc(w io.Writer, text string, colors ...Color) (int, error) {
 	return df.Fprintc(w, text, colors...)
 }
 
@@ -212,13 +421,23 @@ func Sprintf(text string, a ...any) string {
 	return df.Sprintf(text, a...)
 }
 
+// SprintfE is a Wrapper for defaultFormatter.SprintfE (Further information in Formatter.SprintfE)
+func SprintfE(text string, a ...any) (string, error) {
+	return df.SprintfE(text, a...)
+}
+
+// AppendSprintf is a Wrapper for defaultFormatter.AppendSprintf (Further information in Formatter.AppendSprintf)
+func AppendSprintf(dst []byte, text string, a ...any) []byte {
+	return df.AppendSprintf(dst, text, a...)
+}
+
 // Printf is a Wrapper for defaultFormatter.Printf (Further information in Formatter.Printf)
-func Printf(text string, a ...any) int {
+func Printf(text string, a ...any) (int, error) {
 	return df.Printf(text, a...)
 }
 
 // Printlnf is a Wrapper for defaultFormatter.Printlnf (Further information in Formatter.Printlnf)
-func Printlnf(text string, a ...any) int {
+func Printlnf(text string, a ...any) (int, error) {
 	return df.Printlnf(text, a...)
 }
 
@@ -228,28 +447,20 @@ func Fprintf(w io.Writer, text string, a ...any) (int, error) {
 }
 
 // Successf is a Wrapper for defaultFormatter.Successf (Further information in Formatter.Successf)
-func Successf(text string, a ...any) int {
+func Successf(text string, a ...any) (int, error) {
 	return df.Successf(text, a...)
 }
 
 // Warningf is a Wrapper for defaultFormatter.Warningf (Further information in Formatter.Warningf)
-func Warningf(text string, a ...any) int {
+func Warningf(text string, a ...any) (int, error) {
 	return df.Warningf(text, a...)
 }
 
 // Errorf is a Wrapper for defaultFormatter.Errorf (Further information in Formatter.Errorf)
-func Errorf(text string, a ...any) int {
+func Errorf(text string, a ...any) (int, error) {
 	return df.Errorf(text, a...)
 }
 
-// Color returns the ANSI escape code for the given colorCode.
-func Color(c colorCode) string {
-	if c < 0 || int(c) >= len(colorValues) {
-		return ""
-	}
-	return colorValues[c]
-}
-
 /*
 Default returns a pointer to the default Formatter instance.
 This is the instance used by the global functions.
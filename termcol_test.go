@@ -1,9 +1,27 @@
 package termcol
 
 import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"sync"
 	"testing"
 )
 
+// TestMain runs the tests with full color support, as the string functions use the profile detected for the output.
+func TestMain(m *testing.M) {
+	for _, k := range []string{"NO_COLOR", "CLICOLOR", "CLICOLOR_FORCE", "COLORTERM", "TERM_PROGRAM", "VTE_VERSION", "KITTY_WINDOW_ID"} {
+		os.Unsetenv(k)
+	}
+	os.Setenv("FORCE_COLOR", "1")
+	os.Setenv("TERM", "xterm-kitty")
+	df.SetOutput(os.Stdout)
+	os.Exit(m.Run())
+}
+
 func TestParseError(t *testing.T) {
 	type testParseError struct {
 		text     string
@@ -19,6 +37,9 @@ func TestParseError(t *testing.T) {
 		{"You &xsee, testing is great, as you find bugs you didn't know existed", 5,
 			"termcol parse error: Illegal character 'x' at index 5\nYou &xsee, testing is...\n     ^ HERE"},
 		{"And can &xfix them", 9, "termcol parse error: Illegal character 'x' at index 9\nAnd can &xfix them\n         ^ HERE"},
+		{"é", 1, "termcol parse error: Illegal character 'é' at index 0\né\n^ HERE"},
+		{"a&é!", 3, "termcol parse error: Illegal character 'é' at index 2\na&é!\n  ^ HERE"},
+		{"\x80\x80", 1, "termcol parse error: Illegal character '\ufffd' at index 1\n\ufffd\ufffd\n ^ HERE"},
 	}
 
 	for _, v := range tests {
@@ -30,54 +51,70 @@ func TestParseError(t *testing.T) {
 }
 
 func TestSprintc(t *testing.T) {
+	// Codes are written separately, as without CombineSequences.
+	f := NewFormatter()
+	f.CombineSequences(false)
+
 	type testSprintc struct {
 		text     string
-		colors   []colorCode
+		colors   []Color
 		expected string
 	}
 
 	tests := []testSprintc{
-		{"i love &go", []colorCode{Red}, "i love \033[31mgo\033[0m"},
-		{"i &love &go too", []colorCode{Red, Green}, "i \033[31mlove \033[32mgo too\033[0m"},
-		{"go&& is &&&an awe&some language&&", []colorCode{Red, Green}, "go& is &\033[31man awe\033[32msome language&\033[0m"},
-		{"&&&it's & &easy& to learn &&&&&&& and understand&&&", []colorCode{Red, Bold, Green, Yellow, Blue, Reset}, "&\033[31mit's \033[1m\033[32measy\033[33m to learn &&&\033[34m and understand&\033[0m"},
-		{"&Testing is & & &important&!", []colorCode{Red, Underline, Bold, Green, Reset}, "\033[31mTesting is \033[4m\033[1m\033[32mimportant\033[0m!"},
-		{"", []colorCode{}, ""},
-		{"&&&&&&", []colorCode{}, "&&&"},
-		{"&Hello\n&World", []colorCode{Red, Green}, "\033[31mHello\033[0m\n\033[32mWorld\033[0m"},
-		{"&Bold text", []colorCode{Bold}, "\033[1mBold text\033[0m"},
-		{"&Bold &Italic§", []colorCode{Bold, Italic}, "\033[1mBold \033[3mItalic\033[0m"},
-		{"&Bold § normal", []colorCode{Bold}, "\033[1mBold \033[0m normal"},
-		{"&你好 &世界", []colorCode{Red, Green}, "\033[31m你好 \033[32m世界\033[0m"},
-		{"&Fg &Bg &Style§ & & & &Styles combined", []colorCode{Red, GreenBg, Bold, BrightBlue, GrayBg, Italic, Bold}, "\033[31mFg \033[42mBg \033[1mStyle\033[0m \033[94m\033[100m\033[3m\033[1mStyles combined\033[0m"},
-		{"§", []colorCode{}, "\033[0m"},
+		{"i love &go", []Color{Red}, "i love \033[31mgo\033[0m"},
+		{"i &love &go too", []Color{Red, Green}, "i \033[31mlove \033[32mgo too\033[0m"},
+		{"go&& is &&&an awe&some language&&", []Color{Red, Green}, "go& is &\033[31man awe\033[32msome language&\033[0m"},
+		{"&&&it's & &easy& to learn &&&&&&& and understand&&&", []Color{Red, Bold, Green, Yellow, Blue, Reset}, "&\033[31mit's \033[1m\033[32measy\033[33m to learn &&&\033[34m and understand&\033[0m"},
+		{"&Testing is & & &important&!", []Color{Red, Underline, Bold, Green, Reset}, "\033[31mTesting is \033[4m\033[1m\033[32mimportant\033[0m!"},
+		{"", []Color{}, ""},
+		{"&&&&&&", []Color{}, "&&&"},
+		{"&Hello\n&World", []Color{Red, Green}, "\033[31mHello\033[0m\n\033[32mWorld\033[0m"},
+		{"&Bold text", []Color{Bold}, "\033[1mBold text\033[0m"},
+		{"&Bold &Italic§", []Color{Bold, Italic}, "\033[1mBold \033[3mItalic\033[0m"},
+		{"&Bold § normal", []Color{Bold}, "\033[1mBold \033[0m normal"},
+		{"&你好 &世界", []Color{Red, Green}, "\033[31m你好 \033[32m世界\033[0m"},
+		{"&Fg &Bg &Style§ & & & &Styles combined", []Color{Red, GreenBg, Bold, BrightBlue, GrayBg, Italic, Bold}, "\033[31mFg \033[42mBg \033[1mStyle\033[0m \033[94m\033[100m\033[3m\033[1mStyles combined\033[0m"},
+		{"§", []Color{}, "\033[0m"},
+		{"&Orange & &on blue", []Color{RGB(255, 136, 0), Bold, RGBBg(0, 0, 255)}, "\033[38;2;255;136;0mOrange \033[1m\033[48;2;0;0;255mon blue\033[0m"},
+		{"&Hex\n&Short", []Color{Hex("#0a0B0c"), HexBg("f80")}, "\033[38;2;10;11;12mHex\033[0m\n\033[48;2;255;136;0mShort\033[0m"},
+		{"&Orange &on gray§", []Color{Color256(208), Color256Bg(236)}, "\033[38;5;208mOrange \033[48;5;236mon gray\033[0m"},
+		{"&Curly & &red§ &Dotted &Dashed", []Color{CurlyUnderline, UnderlineRGB(255, 0, 0), Underline256(196), DottedUnderline, DashedUnderline}, "\033[4:3mCurly \033[58;2;255;0;0m\033[58;5;196mred\033[0m \033[4:4mDotted \033[4:5mDashed\033[0m"},
+		{"&Dim &Blink &Reverse &Hidden &Overline &Double", []Color{Dim, Blink, Reverse, Hidden, Overline, DoubleUnderline}, "\033[2mDim \033[5mBlink \033[7mReverse \033[8mHidden \033[53mOverline \033[21mDouble\033[0m"},
 	}
 
 	for _, v := range tests {
-		if result := Sprintc(v.text, v.colors...); result != v.expected {
+		if result := f.Sprintc(v.text, v.colors...); result != v.expected {
 			t.Errorf("\nc(%s, %v)\ngot\n%s\nexpected\n%s", v.text, v.colors, result, v.expected)
 		}
 	}
 
 	type testSprintcErr struct {
 		text     string
-		colors   []colorCode
+		colors   []Color
 		expected string
 	}
 	errTests := []testSprintcErr{
-		{"&Hello &World", []colorCode{Red, Green, Blue}, "termcol: Number of colors (3) does not match number of keys (2)\n&Hello &World"},
-		{"&only one", []colorCode{}, "termcol: Number of colors (0) does not match number of keys (1)\n&only one"},
-		{"Hello World", []colorCode{Red}, "termcol: Number of colors (1) does not match number of keys (0)\nHello World"},
+		{"&Hello &World", []Color{Red, Green, Blue}, "termcol: Number of colors (3) does not match number of keys (2)\n&Hello &World"},
+		{"&only one", []Color{}, "termcol: Number of colors (0) does not match number of keys (1)\n&only one"},
+		{"Hello World", []Color{Red}, "termcol: Number of colors (1) does not match number of keys (0)\nHello World"},
+		{"&Bad hex", []Color{Hex("#ff88")}, "termcol: Invalid color code -1 as argument 2\n&Bad hex"},
+		{"&Bad underline", []Color{UnderlineRGB(0, 0, 0) | bgColor}, "termcol: Invalid color code 1744830464 as argument 2\n&Bad underline"},
+		{"&Ok &Bad palette", []Color{Color256(0), paletteColor | 256}, "termcol: Invalid color code 268435712 as argument 3\n&Ok &Bad palette"},
 	}
 
 	for _, v := range errTests {
-		if res := Sprintc(v.text, v.colors...); res != v.expected {
+		if res := f.Sprintc(v.text, v.colors...); res != v.expected {
 			t.Errorf("\nc(%s, %v)\ngot\n%s\nexpected\n%s", v.text, v.colors, res, v.expected)
 		}
 	}
 }
 
 func TestSprintf(t *testing.T) {
+	// Codes are written separately, as without CombineSequences.
+	f := NewFormatter()
+	f.CombineSequences(false)
+
 	type testSprintf struct {
 		text     string
 		a        []any
@@ -99,10 +136,17 @@ func TestSprintf(t *testing.T) {
 		{"&r你好 &g世界", []any{}, "\033[31m你好 \033[32m世界\033[0m"},
 		{"&rFg &FStyle§ &B&I&FStyles combined", []any{}, "\033[31mFg \033[1mStyle\033[0m \033[94m\033[3m\033[1mStyles combined\033[0m"},
 		{"§", []any{}, "\033[0m"},
+		{"&{#ff8800}%s &{bg:#00f}on blue", []any{"Orange"}, "\033[38;2;255;136;0mOrange \033[48;2;0;0;255mon blue\033[0m"},
+		{"&{#FF8800}Hex\n&{bg:#0a0b0c}Bg", []any{}, "\033[38;2;255;136;0mHex\033[0m\n\033[48;2;10;11;12mBg\033[0m"},
+		{"&{208}%s &{bg:236}on gray§", []any{"Orange"}, "\033[38;5;208mOrange \033[48;5;236mon gray\033[0m"},
+		{"&!r%s &{bg:BrightBlue}&{yellow}on &!Wwhite", []any{"Red"}, "\033[41mRed \033[104m\033[33mon \033[107mwhite\033[0m"},
+		{"&{curly}&{ul:#f00}Curly§ &{dotted}&{ul:red}Dotted &{dashed}&{ul:208}Dashed", []any{}, "\033[4:3m\033[58;2;255;0;0mCurly\033[0m \033[4:4m\033[58;5;1mDotted \033[4:5m\033[58;5;208mDashed\033[0m"},
+		{"&DDim &KBlink &VReverse &XHidden &OOverline &EDouble§ &{dim}&{reverse}", []any{}, "\033[2mDim \033[5mBlink \033[7mReverse \033[8mHidden \033[53mOverline \033[21mDouble\033[0m \033[2m\033[7m\033[0m"},
+		{"&!!r&{bg:bold}", []any{}, "[termcol: Invalid background key '!']r&{bg:bold}"},
 	}
 
 	for _, v := range tests {
-		if result := Sprintf(v.text, v.a...); result != v.expected {
+		if result := f.Sprintf(v.text, v.a...); result != v.expected {
 			t.Errorf("\nc(%s, %v)\ngot\n%s\nexpected\n%s", v.text, v.a, result, v.expected)
 		}
 	}
@@ -116,15 +160,22 @@ func TestSprintf(t *testing.T) {
 		{"&Hello &World", []any{}, "[termcol: Invalid color key 'H']ello &World"},
 		{"&only one %s", []any{"str"}, "[termcol: Invalid color key 'o']nly one str"},
 		{"Hello World", []any{}, "Hello World"},
+		{"&{#ff88}Bad &rhex", []any{}, "[termcol: Invalid color value '#ff88']Bad &rhex"},
+		{"Open &{#ff8800", []any{}, "Open [termcol: Missing '}' in color key]"},
+		{"&{256}Out of range", []any{}, "[termcol: Invalid color value '256']Out of range"},
+		{"&rRed &!F", []any{}, "&rRed [termcol: Invalid background key 'F']"},
+		{"&{bg:bold}Bold", []any{}, "[termcol: Invalid color value 'bg:bold']Bold"},
+		{"&{ul:bg:red}Underline", []any{}, "[termcol: Invalid color value 'ul:bg:red']Underline"},
+		{"End &!", []any{}, "End [termcol: Color key without value at end of text]"},
 	}
 
 	for _, v := range errTests {
-		if res := Sprintf(v.text, v.a...); res != v.expected {
+		if res := f.Sprintf(v.text, v.a...); res != v.expected {
 			t.Errorf("c(%s, %v)\ngot\n%s\nexpected\n%s", v.text, v.a, res, v.expected)
 		}
 	}
 
-	f := NewFormatter()
+	f = NewFormatter()
 	f.SetKey('N')
 	f.SetResetKey('n')
 	f.ResetAtEnd(false)
@@ -143,3 +194,1113 @@ func TestSprintf(t *testing.T) {
 		}
 	}
 }
+
+func TestDetectProfile(t *testing.T) {
+	type testDetectProfile struct {
+		env      map[string]string
+		expected Profile
+	}
+
+	tests := []testDetectProfile{
+		{map[string]string{}, NoColor},
+		{map[string]string{"TERM": "xterm-256color"}, NoColor},
+		{map[string]string{"FORCE_COLOR": "1"}, ANSI},
+		{map[string]string{"FORCE_COLOR": "1", "TERM": "xterm-256color"}, ANSI256},
+		{map[string]string{"FORCE_COLOR": "3", "TERM": "dumb"}, TrueColor},
+		{map[string]string{"FORCE_COLOR": "2", "COLORTERM": "truecolor"}, TrueColor},
+		{map[string]string{"FORCE_COLOR": "0", "CLICOLOR_FORCE": "1"}, NoColor},
+		{map[string]string{"CLICOLOR_FORCE": "1", "TERM": "xterm-direct"}, TrueColor},
+		{map[string]string{"CLICOLOR_FORCE": "1", "TERM": "xterm-kitty", "COLORTERM": "24bit"}, Extended},
+		{map[string]string{"FORCE_COLOR": "1", "TERM": "xterm-256color", "VTE_VERSION": "7600"}, Extended},
+		{map[string]string{"FORCE_COLOR": "1", "TERM": "xterm-256color", "VTE_VERSION": "5000"}, ANSI256},
+		{map[string]string{"FORCE_COLOR": "1", "TERM_PROGRAM": "WezTerm"}, Extended},
+		{map[string]string{"CLICOLOR_FORCE": "0"}, NoColor},
+		{map[string]string{"NO_COLOR": "1", "FORCE_COLOR": "3"}, NoColor},
+	}
+
+	for _, v := range tests {
+		for _, k := range []string{"NO_COLOR", "FORCE_COLOR", "CLICOLOR", "CLICOLOR_FORCE", "TERM", "COLORTERM", "TERM_PROGRAM", "VTE_VERSION", "KITTY_WINDOW_ID"} {
+			t.Setenv(k, v.env[k])
+			if _, ok := v.env[k]; !ok {
+				os.Unsetenv(k)
+			}
+		}
+		if result := DetectProfile(&bytes.Buffer{}); result != v.expected {
+			t.Errorf("DetectProfile(%v)\ngot %d, expected %d", v.env, result, v.expected)
+		}
+	}
+}
+
+func TestProfile(t *testing.T) {
+	f := NewFormatter()
+	f.SetProfile(NoColor)
+
+	if result := f.Sprintf("&r%s &{bg:208}§%d\n", "plain", 1); result != "plain 1\n" {
+		t.Errorf("Sprintf with NoColor\ngot %q", result)
+	}
+	if result := f.Sprintc("&plain &text", Red, RGB(1, 2, 3)); result != "plain text" {
+		t.Errorf("Sprintc with NoColor\ngot %q", result)
+	}
+
+	t.Setenv("NO_COLOR", "1")
+	var b bytes.Buffer
+	f = NewFormatter()
+	if _, err := f.Fprintf(&b, "&r%s", "plain"); err != nil || b.String() != "plain" {
+		t.Errorf("Fprintf with NO_COLOR\ngot %q", b.String())
+	}
+	if result := f.Sprintf("&r%s", "plain"); result != "plain" {
+		t.Errorf("Sprintf with NO_COLOR\ngot %q", result)
+	}
+	if result := f.MustCompile("&r%s").Sprintf("plain"); result != "plain" {
+		t.Errorf("Template.Sprintf with NO_COLOR\ngot %q", result)
+	}
+
+	// The string functions use the profile of the output.
+	t.Setenv("NO_COLOR", "")
+	t.Setenv("TERM", "xterm")
+	f = NewFormatter()
+	if result := f.Sprintf("&{curly}&{ul:208}typo"); result != "\033[4mtypo\033[0m" {
+		t.Errorf("Sprintf with ANSI\ngot %q", result)
+	}
+	t.Setenv("FORCE_COLOR", "")
+	os.Unsetenv("FORCE_COLOR")
+	t.Setenv("CLICOLOR", "0")
+	f = NewFormatter()
+	if result := f.Sprintc("&plain", Red); result != "plain" {
+		t.Errorf("Sprintc with CLICOLOR=0\ngot %q", result)
+	}
+
+	// The profile is detected when the output is set, not on every write.
+	t.Setenv("NO_COLOR", "")
+	t.Setenv("FORCE_COLOR", "1")
+	b.Reset()
+	f.SetOutput(&b)
+	t.Setenv("FORCE_COLOR", "0")
+	f.Printf("&r%s", "red")
+	if b.String() != "\033[31mred\033[0m" {
+		t.Errorf("Printf after changing the environment\ngot %q", b.String())
+	}
+	b.Reset()
+	f.SetOutput(&b)
+	f.Printf("&r%s", "plain")
+	if b.String() != "plain" {
+		t.Errorf("Printf after SetOutput\ngot %q", b.String())
+	}
+}
+
+func TestDownsample(t *testing.T) {
+	type testPalette struct {
+		n        uint8
+		expected [3]uint8
+	}
+
+	paletteTests := []testPalette{
+		{1, [3]uint8{205, 0, 0}},
+		{16, [3]uint8{0, 0, 0}},
+		{67, [3]uint8{95, 135, 175}},
+		{208, [3]uint8{255, 135, 0}},
+		{231, [3]uint8{255, 255, 255}},
+		{232, [3]uint8{8, 8, 8}},
+		{255, [3]uint8{238, 238, 238}},
+	}
+
+	for _, v := range paletteTests {
+		if r, g, b := paletteRGB(v.n); [3]uint8{r, g, b} != v.expected {
+			t.Errorf("paletteRGB(%d)\ngot %v, expected %v", v.n, [3]uint8{r, g, b}, v.expected)
+		}
+	}
+
+	type testDownsample struct {
+		profile  Profile
+		color    Color
+		expected Color
+	}
+
+	tests := []testDownsample{
+		{TrueColor, RGB(255, 136, 0), RGB(255, 136, 0)},
+		{ANSI256, RGB(255, 136, 0), Color256(208)},
+		{ANSI256, RGBBg(128, 128, 128), Color256Bg(244)},
+		{ANSI256, RGB(0, 0, 128), Color256(18)},
+		{ANSI256, RGB(18, 18, 18), Color256(233)},
+		{ANSI256, Color256(208), Color256(208)},
+		{ANSI, RGB(0, 0, 0), Black},
+		{ANSI, RGB(255, 255, 255), BrightWhite},
+		{ANSI, RGB(200, 30, 30), Red},
+		{ANSI, RGBBg(0, 0, 128), BlueBg},
+		{ANSI, Color256(9), BrightRed},
+		{ANSI, Color256Bg(2), GreenBg},
+		{ANSI, Color256(46), BrightGreen},
+		{ANSI, Color256(244), Gray},
+		{ANSI, Color256Bg(232), BlackBg},
+		{ANSI, Bold, Bold},
+	}
+
+	for _, v := range tests {
+		if result := downsample(v.profile, v.color); result != v.expected {
+			t.Errorf("downsample(%d, %d)\ngot %d, expected %d", v.profile, v.color, result, v.expected)
+		}
+	}
+
+	type testDist struct {
+		a, b     lab
+		expected float64
+	}
+
+	// Reference values from Sharma, Wu and Dalal, "The CIEDE2000 Color-Difference Formula".
+	distTests := []testDist{
+		{lab{50, 2.6772, -79.7751}, lab{50, 0, -82.7485}, 2.0425},
+		{lab{50, 2.5, 0}, lab{50, 0, -2.5}, 4.3065},
+		{lab{50, 2.5, 0}, lab{73, 25, -18}, 27.1492},
+		{lab{60.2574, -34.0099, 36.2677}, lab{60.4626, -34.1751, 39.4387}, 1.2644},
+	}
+
+	for _, v := range distTests {
+		if result := math.Sqrt(v.a.dist(v.b)); math.Abs(result-v.expected) > 0.0001 {
+			t.Errorf("dist(%v, %v)\ngot %.4f, expected %.4f", v.a, v.b, result, v.expected)
+		}
+	}
+
+	f := NewFormatter()
+	f.SetProfile(ANSI)
+	if result := f.Sprintf("&{#ff0000}Red &{bg:21}on blue"); result != "\033[91mRed \033[44mon blue\033[0m" {
+		t.Errorf("Sprintf with ANSI\ngot %q", result)
+	}
+	f.SetProfile(ANSI256)
+	if result := f.Sprintc("&Orange", RGB(255, 136, 0)); result != "\033[38;5;208mOrange\033[0m" {
+		t.Errorf("Sprintc with ANSI256\ngot %q", result)
+	}
+	f.SetProfile(TrueColor)
+	if result := f.Sprintf("&{curly}&{ul:#ff0000}Typo§ &{dashed}ok"); result != "\033[4mTypo\033[0m \033[4mok\033[0m" {
+		t.Errorf("Sprintf with TrueColor\ngot %q", result)
+	}
+}
+
+func TestTargetedResets(t *testing.T) {
+	// Codes are written separately, as without CombineSequences.
+	f := NewFormatter()
+	f.CombineSequences(false)
+
+	type testTargetedResets struct {
+		text     string
+		a        []any
+		expected string
+	}
+
+	tests := []testTargetedResets{
+		{"&r&F%s&{/bold} red", []any{"bold"}, "\033[31m\033[1mbold\033[22m red\033[0m"},
+		{"&!b&U%s&{/underline}&{/bg}&{/fg}", []any{"text"}, "\033[44m\033[4mtext\033[24m\033[49m\033[39m"},
+		{"&{curly}&{ul:#f00}typo&{/ul}§", []any{}, "\033[4:3m\033[58;2;255;0;0mtypo\033[59m\033[0m"},
+	}
+
+	for _, v := range tests {
+		if result := f.Sprintf(v.text, v.a...); result != v.expected {
+			t.Errorf("\nc(%s, %v)\ngot\n%q\nexpected\n%q", v.text, v.a, result, v.expected)
+		}
+	}
+
+	if result := f.Sprintc("&Bold & &red&", Bold, BoldOff, Red, DefaultFg); result != "\033[1mBold \033[22m\033[31mred\033[39m" {
+		t.Errorf("Sprintc with off codes\ngot %q", result)
+	}
+
+	f.TargetedResets(true)
+
+	tests = []testTargetedResets{
+		{"&r&F%s", []any{"bold red"}, "\033[31m\033[1mbold red\033[22m\033[39m"},
+		{"&F%s§ normal", []any{"bold"}, "\033[1mbold\033[22m normal"},
+		{"&!g&{#ff8800}&I%s\n&Unext line", []any{"line"}, "\033[42m\033[38;2;255;136;0m\033[3mline\033[23m\033[39m\033[49m\n\033[4mnext line\033[24m"},
+		{"&D&Fboth§§&{/dim}", []any{}, "\033[2m\033[1mboth§\033[22m"},
+		{"plain§\n", []any{}, "plain\n"},
+		{"&{curly}&{ul:208}&K&V&X&S&Oall", []any{}, "\033[4:3m\033[58;5;208m\033[5m\033[7m\033[8m\033[9m\033[53mall\033[24m\033[25m\033[27m\033[28m\033[29m\033[55m\033[59m"},
+	}
+
+	for _, v := range tests {
+		if result := f.Sprintf(v.text, v.a...); result != v.expected {
+			t.Errorf("\nc(%s, %v)\ngot\n%q\nexpected\n%q", v.text, v.a, result, v.expected)
+		}
+	}
+
+	if result := f.Sprintc("&Bold &red&", Bold, Red, Reset); result != "\033[1mBold \033[31mred\033[22m\033[39m" {
+		t.Errorf("Sprintc with TargetedResets\ngot %q", result)
+	}
+}
+
+func TestStyleStack(t *testing.T) {
+	type testStyleStack struct {
+		text     string
+		a        []any
+		expected string
+	}
+
+	f := NewFormatter()
+	f.CombineSequences(false)
+	f.StyleStack(true)
+
+	tests := []testStyleStack{
+		{"&rThe &F%s§ word is red§ plain", []any{"bold"}, "\033[31mThe \033[1mbold\033[22m word is red\033[0m plain"},
+		{"&r&!bouter &g&Iinner§ outer§", []any{}, "\033[31m\033[44mouter \033[32m\033[3minner\033[23m\033[31m outer\033[0m"},
+		{"&rred &Ubold &{#ff8800}orange§ underlined§ red", []any{}, "\033[31mred \033[4mbold \033[38;2;255;136;0morange\033[31m underlined\033[24m red\033[0m"},
+		{"&D&I&U&S&rall &Fbold§ none§", []any{}, "\033[2m\033[3m\033[4m\033[9m\033[31mall \033[1mbold\033[22m\033[2m none\033[0m"},
+		{"&rred\n&ggreen§ none§", []any{}, "\033[31mred\033[0m\n\033[32mgreen\033[0m none"},
+		{"&!r&s&{/bg}&F&Iblack§ plain§", []any{}, "\033[41m\033[30m\033[49m\033[1m\033[3mblack\033[0m plain\033[0m"},
+		{"&I&r&!y&Fnested &{/fg}&{/bg}default§", []any{}, "\033[3m\033[31m\033[43m\033[1mnested \033[39m\033[49mdefault\033[31m\033[43m\033[0m"},
+		{"§", []any{}, "\033[0m"},
+	}
+
+	for _, v := range tests {
+		if result := f.Sprintf(v.text, v.a...); result != v.expected {
+			t.Errorf("\nc(%s, %v)\ngot\n%q\nexpected\n%q", v.text, v.a, result, v.expected)
+		}
+	}
+
+	if result := f.Sprintc("&red & &bold-blue§ red§", Red, Bold, Blue); result != "\033[31mred \033[1m\033[34mbold-blue\033[22m\033[31m red\033[0m" {
+		t.Errorf("Sprintc with StyleStack\ngot %q", result)
+	}
+
+	f.TargetedResets(true)
+	if result := f.Sprintf("&rred &Fbold§ red§ &{bg:208}bg§"); result != "\033[31mred \033[1mbold\033[22m red\033[39m \033[48;5;208mbg\033[49m" {
+		t.Errorf("Sprintf with StyleStack and TargetedResets\ngot %q", result)
+	}
+}
+
+func TestRestoreAfterNewline(t *testing.T) {
+	type testRestoreAfterNewline struct {
+		text     string
+		a        []any
+		expected string
+	}
+
+	f := NewFormatter()
+	f.CombineSequences(false)
+	f.RestoreAfterNewline(true)
+
+	tests := []testRestoreAfterNewline{
+		{"&r%s\n%s", []any{"first", "second"}, "\033[31mfirst\033[0m\n\033[31msecond\033[0m"},
+		{"&F&!bbold\n\n&gline§\nplain", []any{}, "\033[1m\033[44mbold\033[0m\n\033[1m\033[44m\033[0m\n\033[1m\033[44m\033[32mline\033[0m\033[0m\nplain"},
+		{"plain\n&{208}orange\n", []any{}, "plain\033[0m\n\033[38;5;208morange\033[0m\n\033[38;5;208m\033[0m"},
+	}
+
+	for _, v := range tests {
+		if result := f.Sprintf(v.text, v.a...); result != v.expected {
+			t.Errorf("\nc(%s, %v)\ngot\n%q\nexpected\n%q", v.text, v.a, result, v.expected)
+		}
+	}
+
+	f.ResetBeforeNewline(false)
+	f.TargetedResets(true)
+	if result := f.Sprintc("&Red\n&bold\n&plain\n", Red, Bold, Reset); result != "\033[31mRed\033[39m\n\033[31m\033[1mbold\033[22m\033[39m\n\033[1m\033[31m\033[22m\033[39mplain\n" {
+		t.Errorf("Sprintc with RestoreAfterNewline and TargetedResets\ngot %q", result)
+	}
+}
+
+func TestStyle(t *testing.T) {
+	// Codes are written separately, as without CombineSequences.
+	f := NewFormatter()
+	f.CombineSequences(false)
+
+	type testStyle struct {
+		style    Style
+		text     string
+		expected string
+	}
+
+	tests := []testStyle{
+		{NewStyle().Fg(Red).Bg(BlueBg).Bold(), "text", "\033[1m\033[31m\033[44mtext\033[0m"},
+		{NewStyle(Red, Bold).Bg(Yellow).Fg(RGB(255, 136, 0)), "text", "\033[1m\033[38;2;255;136;0m\033[43mtext\033[0m"},
+		{NewStyle().Italic().Underline().Fg(GreenBg).Bg(Color256(208)), "a\nb", "\033[4m\033[3m\033[48;5;208ma\033[0m\n\033[4m\033[3m\033[48;5;208mb\033[0m"},
+		{NewStyle().CurlyUnderline().UnderlineColor(Red).Dim().Reverse(), "text", "\033[4:3m\033[2m\033[7m\033[58;5;1mtext\033[0m"},
+		{NewStyle(Bold, BoldOff, Hex("#zz")).Fg(-1), "text", "text"},
+	}
+
+	for _, v := range tests {
+		if result := f.Render(v.style, v.text); result != v.expected {
+			t.Errorf("Render(%q)\ngot\n%q\nexpected\n%q", v.text, result, v.expected)
+		}
+	}
+
+	style := NewStyle().Fg(Red).StrikeThrough()
+	if result := f.Sprintc("& &struck§ plain", style.Codes()...); result != "\033[9m\033[31mstruck\033[0m plain" {
+		t.Errorf("Sprintc with Style.Codes\ngot %q", result)
+	}
+
+	f.TargetedResets(true)
+	f.ResetBeforeNewline(false)
+	if result := f.Render(style.Overline(), "a\nb"); result != "\033[9m\033[53m\033[31ma\nb\033[29m\033[55m\033[39m" {
+		t.Errorf("Render with TargetedResets\ngot %q", result)
+	}
+}
+
+func TestCombineSequences(t *testing.T) {
+	type testCombineSequences struct {
+		text     string
+		colors   []Color
+		expected string
+	}
+
+	tests := []testCombineSequences{
+		{"& & &x", []Color{Bold, Red, YellowBg}, "\033[1;31;43mx\033[0m"},
+		{"&Testing is & & &important&!", []Color{Red, Underline, Bold, Green, Reset}, "\033[31mTesting is \033[4;1;32mimportant\033[0m!"},
+		{"&red &still red & &bold", []Color{Red, Red, Reset, Red}, "\033[31mred still red \033[0;31mbold\033[0m"},
+		{"& &red &still red & &bold", []Color{Reset, Red, Red, Reset, Red}, "\033[0;31mred still red bold\033[0m"},
+		{"&Fg &Bg &Style§ & & & &Styles combined", []Color{Red, GreenBg, Bold, BrightBlue, GrayBg, Italic, Bold}, "\033[31mFg \033[42mBg \033[1mStyle\033[0m \033[1;3;94;100mStyles combined\033[0m"},
+		{"&Hello\n&World", []Color{Red, Green}, "\033[31mHello\033[0m\n\033[32mWorld\033[0m"},
+		{"&Orange & &rgb", []Color{Color256(208), Bold, RGBBg(1, 2, 3)}, "\033[38;5;208mOrange \033[1;48;2;1;2;3mrgb\033[0m"},
+		{"§&&", []Color{}, "\033[0m&"},
+		{"§", []Color{}, "\033[0m"},
+	}
+
+	for _, v := range tests {
+		if result := Sprintc(v.text, v.colors...); result != v.expected {
+			t.Errorf("\nc(%s, %v)\ngot\n%q\nexpected\n%q", v.text, v.colors, result, v.expected)
+		}
+	}
+
+	if result := Sprintf("&r&F%s&{/bold}&{red} red", "bold"); result != "\033[1;31mbold\033[22m red\033[0m" {
+		t.Errorf("Sprintf with CombineSequences\ngot %q", result)
+	}
+	if result := NewStyle().Fg(Red).Bg(BlueBg).Bold().Render("text"); result != "\033[1;31;44mtext\033[0m" {
+		t.Errorf("Render with CombineSequences\ngot %q", result)
+	}
+
+	// The style before the text is unknown, so leading resets and off codes are kept.
+	f := NewFormatter()
+	f.ResetAtEnd(false)
+	if result := f.Sprintf("§plain"); result != "\033[0mplain" {
+		t.Errorf("Sprintf with leading reset\ngot %q", result)
+	}
+	if result := f.Sprintf("&{/bold} red&{/bold}"); result != "\033[22m red" {
+		t.Errorf("Sprintf with leading off code\ngot %q", result)
+	}
+	if result := f.Sprintc("&plain", Reset); result != "\033[0mplain" {
+		t.Errorf("Sprintc with leading reset\ngot %q", result)
+	}
+
+	f = NewFormatter()
+	f.StyleStack(true)
+	f.RestoreAfterNewline(true)
+	if result := f.Sprintf("&r&!ya &F&Ub\nc§ d§"); result != "\033[31;43ma \033[4;1mb\033[0m\n\033[4;1;31;43mc\033[22;24m d\033[0m" {
+		t.Errorf("Sprintf with CombineSequences, StyleStack and RestoreAfterNewline\ngot %q", result)
+	}
+}
+
+func TestMinimize(t *testing.T) {
+	type testMinimize struct {
+		text     string
+		expected string
+	}
+
+	tests := []testMinimize{
+		{"\033[0m\033[0m\033[31m\033[31mred\033[0m", "\033[31mred\033[0m"},
+		{"\033[1m\033[31m\033[43mtext\033[0m plain", "\033[1;31;43mtext\033[0m plain"},
+		{"\033[31mred\033[0m\033[31m still red\033[0m", "\033[31mred still red\033[0m"},
+		{"\033[1;31mbold\033[0m\033[31m red\033[m", "\033[1;31mbold\033[22m red\033[0m"},
+		{"\033[38;5;208ma\033[38:5:208mb\033[48;2;1;2;3mc\033[48:2::1:2:3md\033[0m", "\033[38;5;208mab\033[48;2;1;2;3mcd\033[0m"},
+		{"\033[4:3m\033[58:2:255:0:0mtypo\033[24;59m", "\033[4:3;58;2;255;0;0mtypo\033[0m"},
+		{"\033[31mred\033[2K\033[31mline\033[0m", "\033[31mred\033[2Kline\033[0m"},
+		{"\033[31m\033[51mframed\033[0m\033[0m", "\033[31m\033[51mframed\033[0m"},
+		{"\033[1m\033[22mplain\033[38;5mtext\033[", "plain\033[38;5mtext\033["},
+		{"\033[31mred\033[51;0m\033[31mred", "\033[31mred\033[51;0m\033[31mred"},
+		{"\033[51mframed\033[31m\033[0m\033[0m\033[31mred", "\033[51mframed\033[31m\033[0m\033[31mred"},
+		{"\033[51;1mbold\033[22m\033[0;31m\033[31mred", "\033[51;1mbold\033[22m\033[0;31mred"},
+		{"你好\033[0m\xff", "你好\xff"},
+		{"", ""},
+	}
+
+	for _, v := range tests {
+		if result := Minimize(v.text); result != v.expected {
+			t.Errorf("Minimize(%q)\ngot\n%q\nexpected\n%q", v.text, result, v.expected)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	type testErrors struct {
+		text     string
+		colors   []Color
+		a        []any
+		expected ParseError
+		message  string
+	}
+
+	tests := []testErrors{
+		{"&Hello &World", []Color{Red, Green, Blue}, nil,
+			ParseError{Err: "Number of colors (3) does not match number of keys (2)", Text: "&Hello &World", Pos: -1, Rune: -1},
+			"termcol parse error: Number of colors (3) does not match number of keys (2)\n&Hello &World"},
+		{"&ok &bad hex", []Color{Red, Hex("#ff88")}, nil,
+			ParseError{Err: "Invalid color code -1 as argument 3", Text: "&ok &bad hex", Pos: 4, Rune: 4, Line: 1, Column: 5},
+			"termcol parse error: Invalid color code -1 as argument 3\n&ok &bad hex\n    ^ HERE"},
+		{"&Hello &World", nil, []any{},
+			ParseError{Text: "&Hello &World", Pos: 1, Rune: 1, Line: 1, Column: 2},
+			"termcol parse error: Illegal character 'H' at index 1\n&Hello &World\n ^ HERE"},
+		{"&r%s &{#ff88}", nil, []any{"text"},
+			ParseError{Err: "Invalid color value '#ff88'", Text: "&r%s &{#ff88}", Pos: 7, Rune: 7, Line: 1, Column: 8},
+			"termcol parse error: Invalid color value '#ff88'\n&r%s &{#ff88}\n       ^ HERE"},
+		{"Open &{#ff8800", nil, []any{},
+			ParseError{Err: "Missing '}' in color key", Text: "Open &{#ff8800", Pos: 6, Rune: 6, Line: 1, Column: 7},
+			"termcol parse error: Missing '}' in color key\nOpen &{#ff8800\n      ^ HERE"},
+		{"&!F", nil, []any{},
+			ParseError{Err: "Invalid background key 'F'", Text: "&!F", Pos: 2, Rune: 2, Line: 1, Column: 3},
+			"termcol parse error: Invalid background key 'F'\n&!F\n  ^ HERE"},
+		{"End &", nil, []any{},
+			ParseError{Err: "Color key without value at end of text", Text: "End &", Pos: 4, Rune: 4, Line: 1, Column: 5},
+			"termcol parse error: Color key without value at end of text\nEnd &\n    ^ HERE"},
+		{"Größe: &xgroß", nil, []any{},
+			ParseError{Text: "Größe: &xgroß", Pos: 10, Rune: 8, Line: 1, Column: 9},
+			"termcol parse error: Illegal character 'x' at index 8\nGröße: &xgroß\n        ^ HERE"},
+		{"第一行\n第二行 &x", nil, []any{},
+			ParseError{Text: "第一行\n第二行 &x", Pos: 21, Rune: 9, Line: 2, Column: 6},
+			"termcol parse error: Illegal character 'x' at index 9\n第一行\\n第二行 &x\n                ^ HERE"},
+		{"&r日本語 &{#zz}", nil, []any{},
+			ParseError{Err: "Invalid color value '#zz'", Text: "&r日本語 &{#zz}", Pos: 14, Rune: 8, Line: 1, Column: 9},
+			"termcol parse error: Invalid color value '#zz'\n&r日本語 &{#zz}\n           ^ HERE"},
+	}
+
+	for _, v := range tests {
+		var result string
+		var err error
+		if v.a == nil {
+			result, err = SprintcE(v.text, v.colors...)
+		} else {
+			result, err = SprintfE(v.text, v.a...)
+		}
+
+		var pe ParseError
+		if !errors.As(err, &pe) || pe != v.expected || result != "" {
+			t.Errorf("E(%q)\ngot %q, %#v\nexpected %#v", v.text, result, err, v.expected)
+		} else if err.Error() != v.message {
+			t.Errorf("E(%q).Error()\ngot\n%s\nexpected\n%s", v.text, err.Error(), v.message)
+		}
+	}
+
+	if result, err := SprintfE("&r%s", "red"); err != nil || result != "\033[31mred\033[0m" {
+		t.Errorf("SprintfE(%q)\ngot %q, %v", "&r%s", result, err)
+	}
+	if result, err := SprintcE("&red", Red); err != nil || result != "\033[31mred\033[0m" {
+		t.Errorf("SprintcE(%q)\ngot %q, %v", "&red", result, err)
+	}
+	if result := Sprintf("你好&"); result != "你好[termcol: Color key without value at end of text]" {
+		t.Errorf("Sprintf(%q)\ngot %q", "你好&", result)
+	}
+}
+
+func TestErrorMode(t *testing.T) {
+	type testErrorMode struct {
+		text     string
+		colors   []Color
+		a        []any
+		expected string
+	}
+
+	tests := []testErrorMode{
+		{"&Hello &World", []Color{Red, Green, Blue}, nil, "Hello World"},
+		{"&ok & &bad&& hex§§", []Color{Red, Bold, Hex("#ff88")}, nil, "ok bad& hex§"},
+		{"&Hello &r%s", nil, []any{"World"}, "&Hello World"},
+		{"&r%s &{#ff88}&&§ &!r&{bg:red}", nil, []any{"text"}, "text &{#ff88}& "},
+		{"Open &r&{#ff8800", nil, []any{}, "Open &{#ff8800"},
+		{"Bad &!F&!", nil, []any{}, "Bad &!F&!"},
+	}
+
+	var errs []ParseError
+	f := NewFormatter()
+	f.SetErrorCallback(func(err ParseError) {
+		errs = append(errs, err)
+	})
+
+	for i, v := range tests {
+		var result string
+		if v.a == nil {
+			result = f.Sprintc(v.text, v.colors...)
+		} else {
+			result = f.Sprintf(v.text, v.a...)
+		}
+		if result != v.expected {
+			t.Errorf("ErrorCallback(%q)\ngot %q, expected %q", v.text, result, v.expected)
+		}
+		if len(errs) != i+1 || errs[i].Text != v.text {
+			t.Errorf("ErrorCallback(%q)\ngot errors %v", v.text, errs)
+		}
+	}
+
+	f.SetErrorMode(ErrorPlain)
+	if result := f.Sprintf("&x%s", "plain"); result != "&xplain" || len(errs) != len(tests) {
+		t.Errorf("ErrorPlain\ngot %q", result)
+	}
+	if result := f.Sprintf("&r%s", "red"); result != "\033[31mred\033[0m" {
+		t.Errorf("ErrorPlain without error\ngot %q", result)
+	}
+
+	f.SetErrorMode(ErrorPanic)
+	defer func() {
+		if err, ok := recover().(ParseError); !ok || err.Pos != 1 {
+			t.Errorf("ErrorPanic\ngot %v", err)
+		}
+	}()
+	f.Sprintf("&x")
+	t.Errorf("ErrorPanic did not panic")
+}
+
+func TestValidate(t *testing.T) {
+	type testValidate struct {
+		text     string
+		colors   []Color
+		expected []ParseError
+	}
+
+	tests := []testValidate{
+		{"&rfine &{bg:blue}text", nil, nil},
+		{"&Hello &pink &{gren}", nil, []ParseError{
+			{Text: "&Hello &pink &{gren}", Pos: 1, Rune: 1, Line: 1, Column: 2, Suggestion: "did you mean &&H?"},
+			{Text: "&Hello &pink &{gren}", Pos: 8, Rune: 8, Line: 1, Column: 9, Suggestion: "did you mean &&p?"},
+			{Err: "Invalid color value 'gren'", Text: "&Hello &pink &{gren}", Pos: 15, Rune: 15, Line: 1, Column: 16,
+				Suggestion: "did you mean &{green}?"},
+		}},
+		{"&!A &{bg:blu}\n&{ul:red", nil, []ParseError{
+			{Err: "Invalid background key 'A'", Text: "&!A &{bg:blu}\n&{ul:red", Pos: 2, Rune: 2, Line: 1, Column: 3,
+				Suggestion: "did you mean &!a?"},
+			{Err: "Invalid color value 'bg:blu'", Text: "&!A &{bg:blu}\n&{ul:red", Pos: 6, Rune: 6, Line: 1, Column: 7,
+				Suggestion: "did you mean &{bg:blue}?"},
+			{Err: "Missing '}' in color key", Text: "&!A &{bg:blu}\n&{ul:red", Pos: 15, Rune: 15, Line: 2, Column: 2,
+				Suggestion: "did you mean &{ul:red}?"},
+		}},
+		{"&u 100&", nil, []ParseError{
+			{Text: "&u 100&", Pos: 1, Rune: 1, Line: 1, Column: 2, Suggestion: "did you mean &U?"},
+			{Err: "Color key without value at end of text", Text: "&u 100&", Pos: 6, Rune: 6, Line: 1, Column: 7,
+				Suggestion: "did you mean &&?"},
+		}},
+		{"&{zzzzzz}", nil, []ParseError{
+			{Err: "Invalid color value 'zzzzzz'", Text: "&{zzzzzz}", Pos: 2, Rune: 2, Line: 1, Column: 3},
+		}},
+		{"&a &b", []Color{Red, Green}, nil},
+		{"&a &b &c", []Color{Red, -5}, []ParseError{
+			{Err: "Number of colors (2) does not match number of keys (3)", Text: "&a &b &c", Pos: -1, Rune: -1,
+				Suggestion: "use && to write a literal &"},
+			{Err: "Invalid color code -5 as argument 3", Text: "&a &b &c", Pos: 3, Rune: 3, Line: 1, Column: 4},
+		}},
+		{"&a", []Color{Red, Hex("#ff")}, []ParseError{
+			{Err: "Number of colors (2) does not match number of keys (1)", Text: "&a", Pos: -1, Rune: -1},
+			{Err: "Invalid color code -1 as argument 3", Text: "&a", Pos: -1, Rune: -1},
+		}},
+	}
+
+	for _, v := range tests {
+		var err error
+		if v.colors == nil {
+			err = Validatef(v.text)
+		} else {
+			err = Validatec(v.text, v.colors...)
+		}
+
+		var result []ParseError
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, e := range joined.Unwrap() {
+				result = append(result, e.(ParseError))
+			}
+		}
+		if (err == nil) != (v.expected == nil) || len(result) != len(v.expected) {
+			t.Errorf("Validate(%q)\ngot\n%#v\nexpected\n%#v", v.text, err, v.expected)
+			continue
+		}
+		for i := range result {
+			if result[i] != v.expected[i] {
+				t.Errorf("Validate(%q)\ngot\n%#v\nexpected\n%#v", v.text, result[i], v.expected[i])
+			}
+		}
+	}
+
+	err := ParseError{Text: "&Hello", Pos: 1, Suggestion: "did you mean &&H?"}
+	if msg := "termcol parse error: Illegal character 'H' at index 1 (did you mean &&H?)\n&Hello\n ^ HERE"; err.Error() != msg {
+		t.Errorf("ParseError with suggestion\ngot\n%s\nexpected\n%s", err.Error(), msg)
+	}
+}
+
+func TestCompile(t *testing.T) {
+	type testCompile struct {
+		text string
+		a    []any
+	}
+
+	tests := []testCompile{
+		{"&rHello %s&W!", []any{"World"}},
+		{"&{#ff8800}%d%% &{bg:blue}done§\nnext", []any{100}},
+		{"no %s args", nil},
+		{"&F&&bold&r §§ §", nil},
+	}
+
+	f := NewFormatter()
+	f.StyleStack(true)
+	for _, v := range tests {
+		tmpl, err := f.Compile(v.text)
+		if err != nil {
+			t.Errorf("Compile(%q)\ngot error %v", v.text, err)
+			continue
+		}
+		expected := f.Sprintf(v.text, v.a...)
+		if result := tmpl.Sprintf(v.a...); result != expected {
+			t.Errorf("Compile(%q).Sprintf(%v)\ngot\n%q\nexpected\n%q", v.text, v.a, result, expected)
+		}
+		if result := tmpl.Append([]byte("> "), v.a...); string(result) != "> "+expected {
+			t.Errorf("Compile(%q).Append(%v)\ngot\n%q\nexpected\n%q", v.text, v.a, result, "> "+expected)
+		}
+
+		var b bytes.Buffer
+		var fb bytes.Buffer
+		tmpl.Fprintf(&b, v.a...)
+		f.Fprintf(&fb, v.text, v.a...)
+		if b.String() != fb.String() {
+			t.Errorf("Compile(%q).Fprintf(%v)\ngot\n%q\nexpected\n%q", v.text, v.a, b.String(), fb.String())
+		}
+	}
+
+	f.SetProfile(ANSI256)
+	tmpl := f.MustCompile("&{#ff8800}%s")
+	f.SetProfile(NoColor)
+	if result := tmpl.Sprintf("orange"); result != "\033[38;5;208morange\033[0m" {
+		t.Errorf("Compile with ANSI256\ngot %q", result)
+	}
+
+	if _, err := Compile("&x%s"); err == nil || err.(ParseError).Pos != 1 {
+		t.Errorf("Compile(%q)\ngot error %v", "&x%s", err)
+	}
+	defer func() {
+		if err, ok := recover().(ParseError); !ok || err.Err != "Missing '}' in color key" {
+			t.Errorf("MustCompile\ngot %v", err)
+		}
+	}()
+	MustCompile("&{red")
+	t.Errorf("MustCompile did not panic")
+}
+
+// Texts for the benchmarks, with few and many keys
+var (
+	benchShort = "&r[%s]§ &a%s &W%d"
+	benchLong  = strings.Repeat("&rError&W: &{#ff8800}file %s &!b&Fnot found§, &&retrying§ in &{208}%d§s\n", 20)
+)
+
+func BenchmarkSprintf(b *testing.B) {
+	b.ReportAllocs()
+	for b.Loop() {
+		Sprintf(benchShort, "INFO", "request", 200)
+	}
+}
+
+func BenchmarkSprintfLong(b *testing.B) {
+	b.ReportAllocs()
+	for b.Loop() {
+		Sprintf(benchLong)
+	}
+}
+
+func BenchmarkSprintc(b *testing.B) {
+	b.ReportAllocs()
+	for b.Loop() {
+		Sprintc("&INFO §&request &200", Red, Gray, BrightWhite)
+	}
+}
+
+func BenchmarkSprintcLong(b *testing.B) {
+	text := strings.Repeat("&Error&: &file &not found§, && retrying §in &5§s\n", 20)
+	colors := []Color{}
+	for range 20 {
+		colors = append(colors, Red, BrightWhite, RGB(255, 136, 0), Bold, Color256(208))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for b.Loop() {
+		Sprintc(text, colors...)
+	}
+}
+
+func BenchmarkSprintfPlain(b *testing.B) {
+	f := NewFormatter()
+	f.SetProfile(NoColor)
+	b.ReportAllocs()
+	for b.Loop() {
+		f.Sprintf(benchLong)
+	}
+}
+
+func TestMultibyteKeys(t *testing.T) {
+	f := NewFormatter()
+	f.SetResetKey('¤')
+	f.SetKey('§')
+
+	type testMultibyteKeys struct {
+		text     string
+		colors   []Color
+		expected string
+	}
+
+	tests := []testMultibyteKeys{
+		{"§r日本§§語¤ ö", nil, "\033[31m日本§語\033[0m ö"},
+		{"§{#ff8800}größe¤¤§!b x", nil, "\033[38;2;255;136;0mgröße¤\033[44m x\033[0m"},
+		{"日§本 §語", []Color{Red, Green}, "日\033[31m本 \033[32m語\033[0m"},
+		{"§ §x & §y", []Color{Red, Bold, Blue}, "\033[1;31mx & \033[34my\033[0m"},
+		{"ö§x", nil, "ö[termcol: Invalid color key 'x']"},
+	}
+
+	for _, v := range tests {
+		var result string
+		if v.colors == nil {
+			result = f.Sprintf(v.text)
+		} else {
+			result = f.Sprintc(v.text, v.colors...)
+		}
+		if result != v.expected {
+			t.Errorf("\nc(%s, %v)\ngot\n%q\nexpected\n%q", v.text, v.colors, result, v.expected)
+		}
+	}
+}
+
+func TestAppend(t *testing.T) {
+	type testAppend struct {
+		text   string
+		colors []Color
+		a      []any
+	}
+
+	tests := []testAppend{
+		{"&INFO §&request &200", []Color{Red, Gray, BrightWhite}, nil},
+		{"&a &b", []Color{Red}, nil},
+		{"&r[%s]§ &{#ff8800}%d", nil, []any{"INFO", 200}},
+		{"&rno args %s", nil, nil},
+		{"&x%s", nil, []any{"invalid"}},
+		{"", nil, nil},
+	}
+
+	for _, mode := range []ErrorMode{ErrorInline, ErrorPlain} {
+		f := NewFormatter()
+		f.SetErrorMode(mode)
+		for _, v := range tests {
+			var result []byte
+			var expected string
+			if v.colors != nil {
+				result = f.AppendSprintc([]byte("> "), v.text, v.colors...)
+				expected = "> " + f.Sprintc(v.text, v.colors...)
+			} else {
+				result = f.AppendSprintf([]byte("> "), v.text, v.a...)
+				expected = "> " + f.Sprintf(v.text, v.a...)
+			}
+			if string(result) != expected {
+				t.Errorf("Append(%q, %d)\ngot\n%q\nexpected\n%q", v.text, mode, result, expected)
+			}
+		}
+	}
+}
+
+// Set with the race detector, which adds allocations and drops reused buffers
+var raceEnabled bool
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	f, err := New(
+		WithKey('#'),
+		WithResetKey('&'),
+		WithResetAtEnd(false),
+		WithOutput(&buf),
+		WithProfile(ANSI),
+		WithStatusStyles(StatusStyle{Cyan, "OK: "}, StatusStyle{Yellow, "Warning: "}, StatusStyle{Red, "Error: "}),
+	)
+	if err != nil {
+		t.Fatalf("\nunexpected error %v", err)
+	}
+	f.Printf("#r%s& &{red}", "red")
+	f.Successf("done")
+	expected := "\033[31mred\033[0m {red}\033[36mOK: done\n"
+	if buf.String() != expected {
+		t.Errorf("\ngot\n%q\nexpected\n%q", buf.String(), expected)
+	}
+
+	type testNew struct {
+		name string
+		opts []Option
+	}
+
+	tests := []testNew{
+		{"Key conflict", []Option{WithKey('§')}},
+		{"Reset key conflict", []Option{WithKey('#'), WithResetKey('#')}},
+		{"Invalid key", []Option{WithKey(-1)}},
+		{"Space key", []Option{WithKey(' ')}},
+		{"Newline key", []Option{WithKey('\n')}},
+		{"Brace reset key", []Option{WithResetKey('{')}},
+		{"Exclamation mark reset key", []Option{WithResetKey('!')}},
+		{"Invalid profile", []Option{WithProfile(Extended + 1)}},
+		{"Invalid error mode", []Option{WithErrorMode(-1)}},
+		{"Nil callback", []Option{WithErrorCallback(nil)}},
+		{"Nil output", []Option{WithOutput(nil)}},
+		{"Invalid status color", []Option{WithStatusStyles(StatusStyle{Green, ""}, StatusStyle{-1, ""}, StatusStyle{Red, ""})}},
+	}
+
+	for _, v := range tests {
+		if _, err := New(v.opts...); err == nil {
+			t.Errorf("\n%s\nexpected an error", v.name)
+		}
+	}
+
+	// The setters ignore conflicting and reserved keys.
+	f = NewFormatter()
+	for _, k := range []rune{'§', ' ', '\n', '{', '!'} {
+		f.SetKey(k)
+	}
+	for _, k := range []rune{'&', ' ', '\n', '{', '!'} {
+		f.SetResetKey(k)
+	}
+	if result := f.Sprintf("&rred§ &{red}x"); result != "\033[31mred\033[0m \033[31mx\033[0m" {
+		t.Errorf("\nSetKey and SetResetKey\ngot\n%q\nexpected\n%q", result, "\033[31mred\033[0m \033[31mx\033[0m")
+	}
+
+	if _, err := New(WithKey('§'), WithResetKey('&')); err != nil {
+		t.Errorf("\nSwapped keys\nunexpected error %v", err)
+	}
+	if _, err := New(WithKey('§')); !errors.Is(err, ErrKeyConflict) {
+		t.Errorf("\ngot\n%v\nexpected\n%v", err, ErrKeyConflict)
+	}
+}
+
+type errWriter struct{}
+
+func (errWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestOutput(t *testing.T) {
+	var out, diag bytes.Buffer
+	f := NewFormatter()
+	f.SetProfile(ANSI)
+	f.SetOutput(&out)
+	f.SetDiagnosticOutput(&diag)
+
+	f.Printc("&text ", Red)
+	f.Printf("&g%s ", "text")
+	f.Printlnc("&text", Blue)
+	f.Printlnf("&y%d", 1)
+	f.Successf("done")
+	f.Warningf("careful")
+	f.Errorf("failed: %s", "reason")
+
+	expected := "\033[31mtext \033[0m\033[32mtext \033[0m\033[34mtext\033[0m\n\033[33m1\033[0m\n\033[32mSuccess: done\033[0m\n"
+	if out.String() != expected {
+		t.Errorf("\nOutput\ngot\n%q\nexpected\n%q", out.String(), expected)
+	}
+	expected = "\033[33mWarning: careful\033[0m\n\033[31mError: failed: reason\033[0m\n"
+	if diag.String() != expected {
+		t.Errorf("\nDiagnostic output\ngot\n%q\nexpected\n%q", diag.String(), expected)
+	}
+
+	f = MustNew(WithOutput(errWriter{}), WithDiagnosticOutput(errWriter{}))
+	type testOutput struct {
+		name string
+		fn   func() (int, error)
+	}
+
+	tests := []testOutput{
+		{"Printc", func() (int, error) { return f.Printc("&text", Red) }},
+		{"Printlnc", func() (int, error) { return f.Printlnc("&text", Red) }},
+		{"Printf", func() (int, error) { return f.Printf("&rtext") }},
+		{"Printlnf", func() (int, error) { return f.Printlnf("&rtext") }},
+		{"Successf", func() (int, error) { return f.Successf("text") }},
+		{"Warningf", func() (int, error) { return f.Warningf("text") }},
+		{"Errorf", func() (int, error) { return f.Errorf("text") }},
+	}
+
+	for _, v := range tests {
+		if _, err := v.fn(); err == nil {
+			t.Errorf("\n%s\nexpected the write error", v.name)
+		}
+	}
+}
+
+func TestLevels(t *testing.T) {
+	var out, diag bytes.Buffer
+	f := MustNew(
+		WithOutput(&out),
+		WithDiagnosticOutput(&diag),
+		WithProfile(ANSI),
+		WithLevel("trace", LevelDebug-4, Magenta, "Trace: "),
+		WithLevel("alert", LevelError+1, BrightYellow, "ALERT "),
+	)
+	f.SetLevel("notice", LevelNotice, White, "Note: ")
+	f.SetLevel("", LevelInfo, Red, "Invalid: ")
+	f.SetLevel("invalid", LevelInfo, -1, "Invalid: ")
+
+	f.Debugf("hidden")
+	f.Infof("started %s", "server")
+	f.Noticef("&Fbold§ notice")
+	f.Logf("alert", "disk full")
+	f.SetMinLevel(LevelDebug - 4)
+	f.Logf("trace", "%d calls", 3)
+	f.Debugf("shown")
+	f.SetMinLevel(LevelError)
+	f.Warningf("hidden")
+	f.Errorf("failed")
+	f.Successf("hidden")
+
+	expected := "\033[34mInfo: started server\033[0m\n\033[37mNote: \033[1mbold\033[0m notice\n" +
+		"\033[35mTrace: 3 calls\033[0m\n\033[90mDebug: shown\033[0m\n"
+	if out.String() != expected {
+		t.Errorf("\nOutput\ngot\n%q\nexpected\n%q", out.String(), expected)
+	}
+	expected = "\033[93mALERT disk full\033[0m\n\033[31mError: failed\033[0m\n"
+	if diag.String() != expected {
+		t.Errorf("\nDiagnostic output\ngot\n%q\nexpected\n%q", diag.String(), expected)
+	}
+
+	for _, name := range []string{"", "invalid", "unknown"} {
+		if _, err := f.Logf(name, "text"); err == nil {
+			t.Errorf("\nLevel %q\nexpected an error", name)
+		}
+	}
+
+	// Changing a level must not affect other Formatters.
+	if _, err := NewFormatter().Logf("trace", "text"); err == nil {
+		t.Errorf("\nLevel %q\nexpected an error", "trace")
+	}
+	if text := defaultLevels["notice"].text; text != "Notice: " {
+		t.Errorf("\nDefault level changed\ngot\n%q\nexpected\n%q", text, "Notice: ")
+	}
+
+	code := -1
+	exit = func(c int) { code = c }
+	defer func() { exit = os.Exit }()
+	f.Fatalf("&rcannot %s", "continue")
+	expected = "\033[91mFatal: \033[31mcannot continue\033[0m\n"
+	if code != 1 || !strings.HasSuffix(diag.String(), expected) {
+		t.Errorf("\nFatalf\ngot\n%q (exit %d)\nexpected suffix\n%q (exit 1)", diag.String(), code, expected)
+	}
+
+	// Fatal messages are written even if the minimum level is above LevelFatal.
+	code = -1
+	diag.Reset()
+	f.SetMinLevel(LevelFatal + 1)
+	f.Errorf("hidden")
+	f.Fatalf("cannot %s", "continue")
+	expected = "\033[91mFatal: cannot continue\033[0m\n"
+	if code != 1 || diag.String() != expected {
+		t.Errorf("\nFatalf above the minimum level\ngot\n%q (exit %d)\nexpected\n%q (exit 1)", diag.String(), code, expected)
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	// Restore the settings of the default Formatter afterwards
+	defer df.cfg.Store(df.load())
+
+	f := NewFormatter()
+	var wg sync.WaitGroup
+	for _, v := range []*Formatter{Default(), f} {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := range 200 {
+				v.SetKey([]rune{'&', '#'}[i%2])
+				v.ResetAtEnd(i%3 != 0)
+				v.SetProfile(Profile(i % int(Extended+1)))
+				v.SetErrorStyle(Color(i%8+30), "Error: ")
+				v.StyleStack(i%2 == 0)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range 200 {
+				v.Sprintf("&r[%s]§ &a%s #bkey", "INFO", "request")
+				v.Fprintc(io.Discard, "&INFO §&request", Red, Gray)
+				v.AppendSprintf(nil, "&{#ff8800}request\n")
+				v.Render(NewStyle(Bold, Red), "text")
+				v.Validatef("&y&{gren}")
+				v.Compile("&Fbold§ %d")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestClone(t *testing.T) {
+	f := NewFormatter()
+	f.SetKey('#')
+	clone := f.Clone()
+	f.SetKey('&')
+	clone.ResetAtEnd(false)
+
+	// The zero Formatter has no key, until one is set.
+	var zero Formatter
+	zero.SetKey('&')
+
+	type testClone struct {
+		name     string
+		f        *Formatter
+		expected string
+	}
+
+	tests := []testClone{
+		{"Formatter", f, "#rred \033[31mred\033[0m"},
+		{"Clone", clone, "\033[31mred &rred"},
+		{"Zero Formatter", &zero, "#rred \033[31mred"},
+	}
+
+	for _, v := range tests {
+		if result := v.f.Sprintf("#rred &rred"); result != v.expected {
+			t.Errorf("\n%s\ngot\n%q\nexpected\n%q", v.name, result, v.expected)
+		}
+	}
+}
+
+func TestAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocations are not counted with the race detector")
+	}
+	buf := make([]byte, 0, 1024)
+	tmpl := MustCompile("&r[%s]§ &a%s")
+	null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer null.Close()
+
+	type testAllocs struct {
+		name     string
+		fn       func()
+		expected float64
+	}
+
+	tests := []testAllocs{
+		{"AppendSprintc", func() { buf = AppendSprintc(buf[:0], "&INFO §&request &200", Red, Gray, RGB(1, 2, 3)) }, 0},
+		{"AppendSprintf", func() { buf = AppendSprintf(buf[:0], "&r[INFO]§ &{#ff8800}request\n&F200") }, 0},
+		{"AppendSprintf with arguments", func() { buf = AppendSprintf(buf[:0], "&r[%s]§ &a%s", "INFO", "request") }, 1},
+		{"Template.Append", func() { buf = tmpl.Append(buf[:0], "INFO", "request") }, 0},
+		{"Template.Fprintf", func() { tmpl.Fprintf(null, "INFO", "request") }, 0},
+		{"Sprintc", func() { Sprintc("&INFO §&request", Red, Gray) }, 1},
+		{"Sprintf", func() { Sprintf("&r[INFO]§ &arequest") }, 1},
+	}
+
+	for _, v := range tests {
+		if allocs := testing.AllocsPerRun(100, v.fn); allocs > v.expected {
+			t.Errorf("%s\ngot %v allocations\nexpected %v", v.name, allocs, v.expected)
+		}
+	}
+}
+
+func BenchmarkAppendSprintf(b *testing.B) {
+	buf := make([]byte, 0, 1024)
+	b.ReportAllocs()
+	for b.Loop() {
+		buf = AppendSprintf(buf[:0], benchShort, "INFO", "request", 200)
+	}
+}
+
+func BenchmarkTemplateFprintf(b *testing.B) {
+	null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer null.Close()
+	tmpl := MustCompile(benchShort)
+	b.ReportAllocs()
+	for b.Loop() {
+		tmpl.Fprintf(null, "INFO", "request", 200)
+	}
+}
+
+func BenchmarkAppendSprintc(b *testing.B) {
+	buf := make([]byte, 0, 1024)
+	b.ReportAllocs()
+	for b.Loop() {
+		buf = AppendSprintc(buf[:0], "&INFO §&request &200", Red, Gray, BrightWhite)
+	}
+}
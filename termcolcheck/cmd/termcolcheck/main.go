@@ -0,0 +1,11 @@
+// Command termcolcheck reports invalid termcol format strings, see package termcolcheck.
+package main
+
+import (
+	"github.com/tyzes/termcol/termcolcheck"
+	"golang.org/x/tools/go/analysis/singlechecker"
+)
+
+func main() {
+	singlechecker.Main(termcolcheck.Analyzer)
+}
@@ -0,0 +1,13 @@
+module github.com/tyzes/termcol/termcolcheck
+
+go 1.24.0
+
+require (
+	github.com/tyzes/termcol v0.0.0-20261018085618-55ef8f9a7f76
+	golang.org/x/tools v0.42.0
+)
+
+require (
+	golang.org/x/mod v0.33.0 // indirect
+	golang.org/x/sync v0.19.0 // indirect
+)
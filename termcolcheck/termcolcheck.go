@@ -0,0 +1,241 @@
+/*
+Package termcolcheck defines an Analyzer reporting invalid termcol format strings at build time.
+
+It checks calls of the termcol print functions and Formatter methods with constant texts for
+invalid color keys, for a mismatch between the number of keys and colors passed to the Sprintc functions,
+and for fmt verbs not matching the arguments of the Sprintf functions after the color keys are removed.
+The keys of the default Formatter are assumed, which can be changed with the -key and -resetkey flags.
+
+It is a separate module, so the termcol package does not depend on golang.org/x/tools.
+The Analyzer can be installed and run with go vet:
+
+	go install github.com/tyzes/termcol/termcolcheck/cmd/termcolcheck@latest
+	go vet -vettool=$(which termcolcheck) ./...
+*/
+package termcolcheck
+
+import (
+	"errors"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tyzes/termcol"
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/inspect"
+	"golang.org/x/tools/go/ast/inspector"
+	"golang.org/x/tools/go/types/typeutil"
+)
+
+// Analyzer reports invalid termcol format strings.
+var Analyzer = &analysis.Analyzer{
+	Name:     "termcolcheck",
+	Doc:      "check termcol format strings for invalid color keys and mismatched arguments",
+	URL:      "https://pkg.go.dev/github.com/tyzes/termcol/termcolcheck",
+	Requires: []*analysis.Analyzer{inspect.Analyzer},
+	Run:      run,
+}
+
+// Keys of the checked format strings, set by the -key and -resetkey flags
+var key, resetKey = "&", "§"
+
+func init() {
+	Analyzer.Flags.StringVar(&key, "key", key, "color key used by the checked code")
+	Analyzer.Flags.StringVar(&resetKey, "resetkey", resetKey, "reset key used by the checked code")
+}
+
+const pkgPath = "github.com/tyzes/termcol"
+
+// Kinds of the checked functions
+const (
+	colorFunc  = iota // Text followed by colors, like Sprintc
+	formatFunc        // Format string followed by fmt arguments, like Sprintf
+)
+
+// Mapping the names of the checked functions and methods to their kind and the index of the text argument
+var funcs = map[string][2]int{
+	"Sprintc":       {colorFunc, 0},
+	"SprintcE":      {colorFunc, 0},
+	"Printc":        {colorFunc, 0},
+	"Printlnc":      {colorFunc, 0},
+	"Fprintc":       {colorFunc, 1},
+	"Validatec":     {colorFunc, 0},
+	"AppendSprintc": {colorFunc, 1},
+	"Sprintf":       {formatFunc, 0},
+	"SprintfE":      {formatFunc, 0},
+	"Printf":        {formatFunc, 0},
+	"Printlnf":      {formatFunc, 0},
+	"Fprintf":       {formatFunc, 1},
+	"AppendSprintf": {formatFunc, 1},
+	"Successf":      {formatFunc, 0},
+	"Warningf":      {formatFunc, 0},
+	"Errorf":        {formatFunc, 0},
+	"Debugf":        {formatFunc, 0},
+	"Infof":         {formatFunc, 0},
+	"Noticef":       {formatFunc, 0},
+	"Fatalf":        {formatFunc, 0},
+	"Logf":          {formatFunc, 1},
+	"Validatef":     {formatFunc, 0},
+	"Compile":       {formatFunc, 0},
+	"MustCompile":   {formatFunc, 0},
+}
+
+func run(pass *analysis.Pass) (any, error) {
+	// plain removes the keys from format strings to check their fmt verbs.
+	f, plain := termcol.NewFormatter(), termcol.NewFormatter()
+	plain.SetProfile(termcol.NoColor)
+	if r, size := utf8.DecodeRuneInString(key); size == len(key) && size != 0 {
+		f.SetKey(r)
+		plain.SetKey(r)
+	}
+	if r, size := utf8.DecodeRuneInString(resetKey); size == len(resetKey) && size != 0 {
+		f.SetResetKey(r)
+		plain.SetResetKey(r)
+	}
+
+	insp := pass.ResultOf[inspect.Analyzer].(*inspector.Inspector)
+	insp.Preorder([]ast.Node{(*ast.CallExpr)(nil)}, func(n ast.Node) {
+		call := n.(*ast.CallExpr)
+		fn, ok := typeutil.Callee(pass.TypesInfo, call).(*types.Func)
+		if !ok || fn.Pkg() == nil || fn.Pkg().Path() != pkgPath || !isTermcolFunc(fn) {
+			return
+		}
+		kind, idx := funcs[fn.Name()][0], funcs[fn.Name()][1]
+		if len(call.Args) <= idx {
+			return
+		}
+
+		tv, ok := pass.TypesInfo.Types[call.Args[idx]]
+		if !ok || tv.Value == nil || tv.Value.Kind() != constant.String {
+			return
+		}
+		text := constant.StringVal(tv.Value)
+		args := call.Args[idx+1:]
+		spread := call.Ellipsis.IsValid()
+
+		if kind == colorFunc {
+			checkColors(pass, f, call.Args[idx], text, args, spread)
+		} else {
+			checkFormat(pass, f, plain, call.Args[idx], text, fn.Name(), args, spread)
+		}
+	})
+	return nil, nil
+}
+
+// isTermcolFunc reports whether fn is one of the checked functions or methods of the Formatter.
+func isTermcolFunc(fn *types.Func) bool {
+	if _, ok := funcs[fn.Name()]; !ok {
+		return false
+	}
+	recv := fn.Type().(*types.Signature).Recv()
+	if recv == nil {
+		return true
+	}
+	ptr, ok := recv.Type().(*types.Pointer)
+	if !ok {
+		return false
+	}
+	named, ok := ptr.Elem().(*types.Named)
+	return ok && named.Obj().Name() == "Formatter"
+}
+
+// checkColors reports invalid keys and colors of a Sprintc call.
+// Colors that are not constant are assumed to be valid.
+func checkColors(pass *analysis.Pass, f *termcol.Formatter, arg ast.Expr, text string, args []ast.Expr, spread bool) {
+	if spread {
+		// The number of colors is unknown.
+		return
+	}
+
+	colors := make([]termcol.Color, len(args))
+	for i, a := range args {
+		if tv, ok := pass.TypesInfo.Types[a]; ok && tv.Value != nil {
+			if v, ok := constant.Int64Val(tv.Value); ok {
+				colors[i] = termcol.Color(v)
+			}
+		}
+	}
+	report(pass, arg, f.Validatec(text, colors...))
+}
+
+// checkFormat reports invalid keys of a Sprintf call and fmt verbs not matching the arguments.
+func checkFormat(pass *analysis.Pass, f, plain *termcol.Formatter, arg ast.Expr, text, name string, args []ast.Expr, spread bool) {
+	if err := f.Validatef(text); err != nil {
+		report(pass, arg, err)
+		return
+	}
+	if len(args) == 0 || spread {
+		// Without arguments, the text is not passed to fmt.Sprintf.
+		return
+	}
+
+	verbs, ok := countVerbs(plain.Sprintf(text))
+	if ok && verbs != len(args) {
+		pass.Reportf(arg.Pos(), "termcol.%s format %q has %d verbs, but %d arguments are given", name, text, verbs, len(args))
+	}
+}
+
+// report reports every ParseError of err at its position in the text argument.
+func report(pass *analysis.Pass, arg ast.Expr, err error) {
+	if err == nil {
+		return
+	}
+
+	errs := []error{err}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	}
+	for _, e := range errs {
+		var pe termcol.ParseError
+		if !errors.As(e, &pe) {
+			continue
+		}
+		msg, _, _ := strings.Cut(pe.Error(), "\n")
+		pass.Reportf(position(arg, pe), "%s", strings.TrimPrefix(msg, "termcol parse error: "))
+	}
+}
+
+// position returns the position of the error in the source code,
+// which is exact if the text is a string literal without escape sequences before the error.
+func position(arg ast.Expr, e termcol.ParseError) token.Pos {
+	lit, ok := arg.(*ast.BasicLit)
+	if !ok || e.Pos < 0 || !strings.HasPrefix(lit.Value[1:], e.Text[:e.Pos]) {
+		return arg.Pos()
+	}
+	return lit.Pos() + 1 + token.Pos(e.Pos)
+}
+
+// countVerbs returns the number of arguments used by the fmt verbs in the format string.
+// If explicit argument indexes are used, false is returned, as the arguments may be used in any order.
+func countVerbs(format string) (int, bool) {
+	n := 0
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		i++
+		for i < len(format) && strings.IndexByte("+-# 0", format[i]) >= 0 {
+			i++
+		}
+		for i < len(format) && (format[i] >= '0' && format[i] <= '9' || format[i] == '.' || format[i] == '*') {
+			if format[i] == '*' {
+				n++
+			}
+			i++
+		}
+		if i == len(format) {
+			break
+		}
+		switch format[i] {
+		case '%':
+		case '[':
+			return 0, false
+		default:
+			n++
+		}
+	}
+	return n, true
+}
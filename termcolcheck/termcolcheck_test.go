@@ -0,0 +1,35 @@
+package termcolcheck
+
+import (
+	"testing"
+
+	"golang.org/x/tools/go/analysis/analysistest"
+)
+
+func TestAnalyzer(t *testing.T) {
+	analysistest.Run(t, analysistest.TestData(), Analyzer, "a")
+}
+
+func TestCountVerbs(t *testing.T) {
+	type testCountVerbs struct {
+		format   string
+		expected int
+		ok       bool
+	}
+
+	tests := []testCountVerbs{
+		{"no verbs", 0, true},
+		{"%s and %d", 2, true},
+		{"100%% of %-8.3f", 1, true},
+		{"%*d %.*s", 4, true},
+		{"%[2]s %[1]s", 0, false},
+		{"trailing %", 0, true},
+	}
+
+	for _, v := range tests {
+		n, ok := countVerbs(v.format)
+		if n != v.expected || ok != v.ok {
+			t.Errorf("countVerbs(%q)\ngot %d, %v\nexpected %d, %v", v.format, n, ok, v.expected, v.ok)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package a
+
+import (
+	"os"
+
+	"github.com/tyzes/termcol"
+)
+
+const greeting = "&rHello %s"
+
+func calls(name string, colors []termcol.Color, text string) {
+	termcol.Sprintf("&rHello %s", name)
+	termcol.Sprintf(greeting, name)
+	termcol.Sprintf("100% &gdone")
+	termcol.Sprintf("&r%d%% done", 100)
+	termcol.Sprintf("%*d &{#ff8800}%[1]s", 3, 4)
+	termcol.Sprintf(text, name)
+	termcol.Sprintc("&a &b", termcol.Red, termcol.Green)
+	termcol.Sprintc("&a &b", colors...)
+	termcol.Sprintc("&a &b", termcol.Red, termcol.Hex("#ff"))
+
+	termcol.Sprintf("&xHello %s", name)         // want `Illegal character 'x' at index 1 \(did you mean &X\?\)`
+	termcol.Printf("&{gren}%s &{bg:blu}", name) // want `Invalid color value 'gren' \(did you mean &\{green\}\?\)` `Invalid color value 'bg:blu'`
+	termcol.Errorf("Failed: %s", name, 1)       // want `termcol.Errorf format "Failed: %s" has 1 verbs, but 2 arguments are given`
+	termcol.Infof("&bStarted %s", name)
+	termcol.Logf("trace", "&a%d calls", 3)
+	termcol.Logf("trace", "&z calls")           // want `Illegal character 'z'`
+	termcol.Logf("trace", "&a%d calls %s", 3)   // want `has 2 verbs, but 1 arguments are given`
+	termcol.Fprintf(os.Stderr, "&r%s %d", name) // want `has 2 verbs, but 1 arguments are given`
+	termcol.Sprintf(greeting + " &")            // want `Color key without value at end of text`
+
+	termcol.Sprintc("&a &b", termcol.Red)                   // want `Number of colors \(1\) does not match number of keys \(2\)`
+	termcol.Printc("&a &b", termcol.Red, termcol.Color(-5)) // want `Invalid color code -5 as argument 3`
+
+	f := termcol.NewFormatter()
+	f.Sprintf("&q")                               // want `Illegal character 'q'`
+	f.Sprintc("&a", termcol.Red, termcol.Green)   // want `Number of colors \(2\) does not match number of keys \(1\)`
+	f.Fprintc(os.Stdout, "&a &b &c", termcol.Red) // want `Number of colors \(1\) does not match number of keys \(3\)`
+	termcol.MustCompile("&r%s &{ul:rde}")         // want `Invalid color value 'ul:rde' \(did you mean &\{ul:red\}\?\)`
+	f.Successf("%s and %s", name)                 // want `has 2 verbs, but 1 arguments are given`
+}
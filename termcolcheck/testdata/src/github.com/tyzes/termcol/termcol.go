@@ -0,0 +1,37 @@
+// Package termcol is a stub of the termcol API for testing the analyzer.
+package termcol
+
+import "io"
+
+type Color int
+
+const (
+	Reset Color = iota
+	Black
+	Red
+	Green
+)
+
+func Hex(s string) Color { return -1 }
+
+type Formatter struct{}
+
+func NewFormatter() *Formatter { return &Formatter{} }
+
+func (f *Formatter) Sprintc(text string, colors ...Color) string                    { return text }
+func (f *Formatter) Fprintc(w io.Writer, text string, colors ...Color) (int, error) { return 0, nil }
+func (f *Formatter) Sprintf(text string, a ...any) string                           { return text }
+func (f *Formatter) Successf(text string, a ...any) (int, error)                    { return 0, nil }
+
+func Sprintc(text string, colors ...Color) string             { return text }
+func Printc(text string, colors ...Color) (int, error)        { return 0, nil }
+func Sprintf(text string, a ...any) string                    { return text }
+func Printf(text string, a ...any) (int, error)               { return 0, nil }
+func Fprintf(w io.Writer, text string, a ...any) (int, error) { return 0, nil }
+func Errorf(text string, a ...any) (int, error)               { return 0, nil }
+func Infof(text string, a ...any) (int, error)                { return 0, nil }
+func Logf(name, text string, a ...any) (int, error)           { return 0, nil }
+
+type Template struct{}
+
+func MustCompile(text string) *Template { return nil }
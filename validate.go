@@ -0,0 +1,193 @@
+package termcol
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+Validatef checks a format string for Sprintf and returns all errors in it, joined with errors.Join, or nil if it is valid.
+Unlike SprintfE, which stops at the first error, every invalid key is reported as a ParseError with a suggested fix if possible.
+The errors can be retrieved by their Unwrap() []error method.
+*/
+func (f *Formatter) Validatef(text string) error {
+	cfg := f.load()
+	var errs []error
+
+	end := 0
+	for _, key := range parse(cfg, text) {
+		if key < end {
+			continue
+		}
+		var color Color
+		var pos int
+		var msg string
+		color, end, pos, msg = parseKey(cfg, text, key)
+		if color != invalidColor {
+			continue
+		}
+
+		err := newParseError(msg, text, pos)
+		err.Suggestion = suggest(cfg, text, key, end)
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+/*
+Validatec checks a text and its colors for Sprintc and returns all errors, joined with errors.Join, or nil if they are valid.
+This reports a mismatch between the number of keys and colors, as well as every invalid color.
+*/
+func (f *Formatter) Validatec(text string, colors ...Color) error {
+	cfg := f.load()
+	keys := parse(cfg, text)
+	var errs []error
+
+	if len(keys) != len(colors) {
+		err := newParseError(
+			fmt.Sprintf("Number of colors (%d) does not match number of keys (%d)", len(colors), len(keys)), text, -1,
+		)
+		if len(keys) > len(colors) {
+			err.Suggestion = fmt.Sprintf("use %c%c to write a literal %c", cfg.key, cfg.key, cfg.key)
+		}
+		errs = append(errs, err)
+	}
+
+	for i, c := range colors {
+		if isColorCode(c) {
+			continue
+		}
+		pos := -1
+		if i < len(keys) {
+			pos = keys[i]
+		}
+		errs = append(errs, newParseError(fmt.Sprintf("Invalid color code %d as argument %d", c, i+2), text, pos))
+	}
+
+	return errors.Join(errs...)
+}
+
+// suggest returns a possible fix for the invalid key at the offsets key to end, or "" if none is found.
+// If no similar key is found, the escaped key is suggested, as the key may be meant literally.
+func suggest(cfg *config, text string, key, end int) string {
+	literal := fmt.Sprintf("did you mean %c%s?", cfg.key, text[key:end])
+	_, size := utf8.DecodeRuneInString(text[key:])
+	i := key + size
+	if i >= len(text) {
+		return literal
+	}
+
+	switch text[i] {
+	case '{':
+		if text[end-1] != '}' {
+			// The closing brace is missing.
+			spec := text[i+1 : end]
+			if parseColorValue(spec) != invalidColor {
+				return fmt.Sprintf("did you mean %c{%s}?", cfg.key, spec)
+			}
+			return ""
+		}
+		if name := nearestName(text[i+1 : end-1]); name != "" {
+			return fmt.Sprintf("did you mean %c{%s}?", cfg.key, name)
+		}
+		return ""
+	case '!':
+		if i+1 >= len(text) {
+			return literal
+		}
+		r, _ := utf8.DecodeRuneInString(text[i+1:])
+		if k, ok := nearestKey(r, true); ok {
+			return fmt.Sprintf("did you mean %c!%c?", cfg.key, k)
+		}
+		return literal
+	}
+
+	r, _ := utf8.DecodeRuneInString(text[i:])
+	if k, ok := nearestKey(r, false); ok {
+		return fmt.Sprintf("did you mean %c%c?", cfg.key, k)
+	}
+	return literal
+}
+
+// nearestKey returns the color key r in the other case, if it exists.
+// With bg, only keys of colors with a background version are returned.
+func nearestKey(r rune, bg bool) (rune, bool) {
+	for _, k := range []rune{unicode.ToLower(r), unicode.ToUpper(r)} {
+		c, ok := colorKeys[k]
+		if k != r && ok && (!bg || background(c) != invalidColor) {
+			return k, true
+		}
+	}
+	return 0, false
+}
+
+// nearestName returns the color name closest to the invalid color value spec, keeping a "bg:" or "ul:" prefix,
+// or "" if no name is close enough.
+func nearestName(spec string) string {
+	prefix := ""
+	for _, p := range []string{"bg:", "ul:"} {
+		if v, ok := strings.CutPrefix(spec, p); ok {
+			prefix, spec = p, v
+		}
+	}
+
+	spec = strings.ToLower(spec)
+	best, bestDist := "", 3
+	for _, name := range sortedNames() {
+		if d := editDistance(spec, name); d < bestDist {
+			best, bestDist = name, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return prefix + best
+}
+
+// sortedNames returns the names of the '{...}' color keys in alphabetical order.
+func sortedNames() []string {
+	names := make([]string, 0, len(colorNames))
+	for name := range colorNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	prev := make([]int, len(t)+1)
+	cur := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(s); i++ {
+		cur[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(t)]
+}
+
+// Validatef is a Wrapper for defaultFormatter.Validatef (Further information in Formatter.Validatef)
+func Validatef(text string) error {
+	return df.Validatef(text)
+}
+
+// Validatec is a Wrapper for defaultFormatter.Validatec (Further information in Formatter.Validatec)
+func Validatec(text string, colors ...Color) error {
+	return df.Validatec(text, colors...)
+}